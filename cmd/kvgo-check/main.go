@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	dbPath := flag.String("db", "./data.db", "Data file")
+	indexPath := flag.String("index", "./indexes.idx", "Index file")
+	repair := flag.Bool("repair", false, "Rebuild the index from the data file and truncate torn tails")
+	keyFile := flag.String("encryption_key_file", "", "File with encryption keys (defaults to $KVGO_ENCRYPTION_KEYS)")
+	flag.Parse()
+
+	var keyRing *kv.KeyRing
+	var err error
+	if *keyFile != "" {
+		keyRing, err = kv.LoadKeyRing(*keyFile)
+	} else {
+		keyRing, err = kv.KeyRingFromEnv()
+	}
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	var report *kv.CheckReport
+	if *repair {
+		report, err = kv.Repair(*dbPath, *indexPath, kv.WithKeyRing(keyRing))
+	} else {
+		report, err = kv.Check(*dbPath, *indexPath, kv.WithKeyRing(keyRing))
+	}
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	fmt.Printf("data:  %d records, %d corrupt, %d byte torn tail\n", report.Records, len(report.CorruptRecords), report.DataTail)
+	fmt.Printf("index: %d entries, %d corrupt, %d byte torn tail\n", report.IndexEntries, report.CorruptIndexEntries, report.IndexTail)
+
+	for _, offset := range report.CorruptRecords {
+		fmt.Printf("corrupt record at offset %d\n", offset)
+	}
+	for _, key := range report.Dangling {
+		fmt.Printf("dangling index entry: %q\n", key)
+	}
+	for _, key := range report.Orphaned {
+		fmt.Printf("orphaned record: %q\n", key)
+	}
+
+	switch {
+	case report.OK():
+		fmt.Println("ok")
+	case *repair:
+		fmt.Println("repaired")
+	default:
+		fmt.Println("problems found; run with -repair to fix them")
+		os.Exit(1)
+	}
+}
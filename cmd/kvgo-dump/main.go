@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+	pb "github.com/kgantsov/kvgo/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+const importChunkSize = 64 << 10
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kvgo-dump export|import|ingest [flags]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	dbPath := flags.String("db", "./data.db", "Data file")
+	indexPath := flags.String("index", "./indexes.idx", "Index file")
+	formatName := flags.String("format", "jsonl", "Dump format: jsonl or binary")
+	file := flags.String("file", "-", "Dump file, - for stdout or stdin")
+	rpcAddr := flags.String("rpc_addr", "", "Import or ingest through the kvgod at this RPC address instead of into the files")
+	keyFile := flags.String("encryption_key_file", "", "File with encryption keys (defaults to $KVGO_ENCRYPTION_KEYS)")
+	dropTTLs := flags.Bool("drop_ttls", false, "Import entries with a ttl as if they had none instead of failing, as kvgo has no expiry")
+	flags.Parse(os.Args[2:])
+
+	format, err := kv.ParseDumpFormat(*formatName)
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	var keyRing *kv.KeyRing
+	if *keyFile != "" {
+		keyRing, err = kv.LoadKeyRing(*keyFile)
+	} else {
+		keyRing, err = kv.KeyRingFromEnv()
+	}
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	var n int
+	switch os.Args[1] {
+	case "export":
+		n, err = export(*dbPath, *indexPath, *file, format, keyRing)
+	case "import", "ingest":
+		ingest := os.Args[1] == "ingest"
+		if *rpcAddr != "" {
+			n, err = importRemote(*rpcAddr, *file, format, ingest, *dropTTLs)
+		} else {
+			var opts []kv.DumpOption
+			if *dropTTLs {
+				opts = append(opts, kv.WithDroppedTTLs())
+			}
+			n, err = importLocal(*dbPath, *indexPath, *file, format, keyRing, ingest, opts...)
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	log.Infof("%sed %d entries", os.Args[1], n)
+}
+
+func export(dbPath, indexPath, path string, format kv.DumpFormat, keyRing *kv.KeyRing) (int, error) {
+	// A read-only open works next to a running kvgod.
+	db, err := kv.OpenKV(dbPath, indexPath, kv.WithReadOnly(), kv.WithKeyRing(keyRing))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	w := os.Stdout
+	if path != "-" {
+		if w, err = os.Create(path); err != nil {
+			return 0, err
+		}
+		defer w.Close()
+	}
+
+	return db.Export(w, format)
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+
+	return os.Open(path)
+}
+
+// importLocal loads the dump into the files, entry by entry or, with ingest,
+// by writing new files directly.
+func importLocal(dbPath, indexPath, path string, format kv.DumpFormat, keyRing *kv.KeyRing, ingest bool, opts ...kv.DumpOption) (n int, err error) {
+	r, err := openInput(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	db, err := kv.OpenKV(dbPath, indexPath, kv.WithKeyRing(keyRing))
+	if err != nil {
+		return 0, err
+	}
+	// Imported entries are only on disk once the store is closed.
+	defer func() {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if ingest {
+		return db.Ingest(r, format, opts...)
+	}
+	return db.Import(r, format, opts...)
+}
+
+// importRemote streams the dump to kvgod, which applies it through Raft so it
+// is replicated to the whole cluster. With ingest, the leader loads it
+// directly and sends it to the followers as a snapshot instead.
+func importRemote(rpcAddr, path string, format kv.DumpFormat, ingest, dropTTLs bool) (int, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	conn, err := grpc.Dial(rpcAddr, grpc.WithInsecure())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	client := pb.NewKVClient(conn)
+
+	var stream interface {
+		Send(*pb.ImportChunk) error
+		CloseAndRecv() (*pb.ImportResponse, error)
+	}
+	if ingest {
+		stream, err = client.Ingest(context.Background())
+	} else {
+		stream, err = client.Import(context.Background())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, importChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.ImportChunk{Format: format.String(), Data: buf[:n], DropTtls: dropTTLs}); err != nil {
+				return 0, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(resp.Imported), nil
+}
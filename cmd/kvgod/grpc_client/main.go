@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"time"
 
+	kv "github.com/kgantsov/kvgo/pkg/kv"
 	pb "github.com/kgantsov/kvgo/pkg/server"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -54,5 +60,158 @@ func main() {
 			log.Fatalf("could not delete key %s: %v", key, err)
 		}
 		fmt.Println("Result: ", r.Exist)
+	case "backup":
+		// backup <file> [previous backup file]
+		if err := backup(c, key, value); err != nil {
+			log.Fatalf("could not back up to %s: %v", key, err)
+		}
+	case "subscribe":
+		// subscribe <file> [from index]
+		if err := subscribe(c, key, value); err != nil {
+			log.Fatalf("could not subscribe to %s: %v", key, err)
+		}
+	}
+}
+
+func backup(c pb.KVClient, path, sincePath string) error {
+	req := &pb.BackupRequest{}
+
+	if sincePath != "" {
+		f, err := os.Open(sincePath)
+		if err != nil {
+			return err
+		}
+		since, err := kv.ReadBackupInfo(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		if req.Since, err = json.Marshal(since); err != nil {
+			return err
+		}
+	}
+
+	stream, err := c.Backup(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+
+	return f.Sync()
+}
+
+// subscribe appends the changes streamed by the server to the file at path,
+// one JSON object per line, until it is stopped. Unless fromIndex is given it
+// resumes after the last change the file holds.
+func subscribe(c pb.KVClient, path, fromIndex string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	last, written, err := lastChange(f)
+	if err != nil {
+		return err
 	}
+
+	// A log entry can make several changes, all with its index, so the
+	// entry of the last change is streamed again and the changes of it the
+	// file holds are skipped.
+	req := &pb.SubscribeRequest{}
+	if last > 0 {
+		req.FromIndex = last - 1
+	}
+	if fromIndex != "" {
+		if req.FromIndex, err = strconv.ParseUint(fromIndex, 10, 64); err != nil {
+			return err
+		}
+		written = 0
+	}
+
+	stream, err := c.Subscribe(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if e.Index == last && written > 0 {
+			written--
+			continue
+		}
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// lastChange returns the index of the last change in the file f and how many
+// of the changes of the file have that index. A torn last line is cut off, and
+// f is left at its end.
+func lastChange(f *os.File) (uint64, int, error) {
+	r := bufio.NewReader(f)
+
+	var last uint64
+	var written int
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var e pb.ChangeEvent
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			return 0, 0, fmt.Errorf("line at offset %d: %v", size, err)
+		}
+		size += int64(len(line))
+
+		if e.Index != last {
+			last = e.Index
+			written = 0
+		}
+		written++
+	}
+
+	if err := f.Truncate(size); err != nil {
+		return 0, 0, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	return last, written, nil
 }
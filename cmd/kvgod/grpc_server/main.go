@@ -18,7 +18,10 @@ func main() {
 	indexPath := filepath.Join(".", "indexes.idx")
 	raftDir := filepath.Join(".", "raft")
 
-	store := server.NewStore(dbPath, indexPath, 4, 100, raftDir, raftAddr)
+	store, err := server.NewStore(dbPath, indexPath, 4, 100, raftDir, raftAddr)
+	if err != nil {
+		log.Fatalf("failed to create store: %s", err.Error())
+	}
 	log.Info("Storage was succesfully created")
 
 	server.ListenAndServGrpc(":50051", store)
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"time"
 
+	kv "github.com/kgantsov/kvgo/pkg/kv"
 	pb "github.com/kgantsov/kvgo/pkg/server"
 	server "github.com/kgantsov/kvgo/pkg/server"
 	log "github.com/sirupsen/logrus"
@@ -23,6 +24,11 @@ func main() {
 	joinAddr := flag.String("join_addr", "", "Join address")
 	nodeID := flag.String("node_id", "", "Node ID")
 	logLevel := flag.String("log_level", "info", "Log level")
+	memTableSize := flag.Int64("memtable_size", kv.DefaultMemTableSize, "Memtable size in bytes")
+	compression := flag.String("compression", "none", "Value compression: none, snappy or zstd")
+	keyFile := flag.String("encryption_key_file", "", "File with encryption keys (defaults to $KVGO_ENCRYPTION_KEYS)")
+	engine := flag.String("engine", "disk", "Storage engine: disk, or memory to keep the data in memory only")
+	commandTimeout := flag.Duration("command_timeout", server.DefaultCommandTimeout, "How long a Redis command may take, 0 for no limit")
 	flag.Parse()
 
 	level, err := log.ParseLevel(*logLevel)
@@ -41,10 +47,47 @@ func main() {
 		log.Fatal("No nodeID storage directory specified\n")
 	}
 
+	codec, err := kv.ParseCompression(*compression)
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
+	var keyRing *kv.KeyRing
+	if *keyFile != "" {
+		keyRing, err = kv.LoadKeyRing(*keyFile)
+	} else {
+		keyRing, err = kv.KeyRingFromEnv()
+	}
+	if err != nil {
+		log.Fatal("Fatal error: ", err.Error())
+	}
+
 	log.Info("Creating storage...")
-	store := server.NewStore(
-		filepath.Join(*raftDir, dbPath), filepath.Join(*raftDir, indexPath), 1000, 10000, *raftDir, *raftAddr,
-	)
+	var store *server.Store
+	switch *engine {
+	case "disk":
+		store, err = server.NewStore(
+			filepath.Join(*raftDir, dbPath),
+			filepath.Join(*raftDir, indexPath),
+			1000,
+			10000,
+			*raftDir,
+			*raftAddr,
+			kv.WithMemTableSize(*memTableSize),
+			kv.WithCompression(codec),
+			kv.WithKeyRing(keyRing),
+		)
+	case "memory":
+		// The Raft log and snapshots are still kept in raft_dir, so the
+		// data is rebuilt from them on restart.
+		store = server.NewStoreWithEngine(kv.NewMemoryKV(), *raftDir, *raftAddr)
+	default:
+		log.Fatalf("unknown engine `%s`", *engine)
+	}
+	if err != nil {
+		log.Fatalf("failed to create store: %s", err.Error())
+	}
+	store.KeyRing = keyRing
 
 	if err := store.Open(*joinAddr == "", *nodeID); err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
@@ -67,6 +110,6 @@ func main() {
 		}
 	}
 
-	go server.ListenAndServ(*addr, store)
+	go server.ListenAndServWithTimeout(*addr, store, *commandTimeout)
 	server.ListenAndServGrpc(*rpcAddr, store)
 }
@@ -27,3 +27,14 @@ func (b *Bool) Set(value bool) {
 		atomic.StoreInt32(&b.value, 0)
 	}
 }
+
+func (b *Bool) CompareAndSwap(old, new bool) bool {
+	var o, n int32
+	if old {
+		o = 1
+	}
+	if new {
+		n = 1
+	}
+	return atomic.CompareAndSwapInt32(&b.value, o, n)
+}
@@ -0,0 +1,597 @@
+package kv
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A backup is a tar archive. Its first entry, BACKUP, holds the BackupInfo
+// describing it and is followed by the bytes of the data file, the index file
+// and the live blob files as of the last commit before the backup was taken.
+//
+// Between compactions the data and index files are only ever appended to,
+// and a blob file is sealed once the next one is started, so an incremental
+// backup taken on top of an earlier one only holds the bytes appended to each
+// file since. Restoring replays a full backup and then the incremental ones
+// taken on top of it, in order.
+const (
+	backupInfoEntry  = "BACKUP"
+	backupDataEntry  = "data"
+	backupIndexEntry = "index"
+	backupBlobPrefix = "blob/"
+	backupTailSize   = 4096
+)
+
+var ErrBackupMismatch = errors.New("kv: backup was not taken on top of the one restored before it")
+
+// BackupInfo describes the files held by a backup.
+type BackupInfo struct {
+	Data  BackupFile            `json:"data"`
+	Index BackupFile            `json:"index"`
+	Blobs map[uint64]BackupFile `json:"blobs"`
+
+	// Since describes the backup an incremental backup was taken on top of.
+	// It is nil for full backups.
+	Since *BackupInfo `json:"since,omitempty"`
+}
+
+// BackupFile describes how much of a file a backup holds.
+type BackupFile struct {
+	Size int64 `json:"size"`
+	// Tail is the checksum of the last bytes held, which tells whether the
+	// file was rewritten by a compaction since.
+	Tail uint32 `json:"tail"`
+}
+
+// ReadBackupInfo returns the BackupInfo of the backup archive read from r.
+func ReadBackupInfo(r io.Reader) (*BackupInfo, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != backupInfoEntry {
+		return nil, fmt.Errorf("kv: not a backup, found %q instead of %s", hdr.Name, backupInfoEntry)
+	}
+
+	info := new(BackupInfo)
+	if err := json.NewDecoder(tr).Decode(info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// backupSource is a file pinned for a backup, up to size bytes.
+type backupSource struct {
+	name string
+	r    io.ReaderAt
+	size int64
+}
+
+// Backup writes a full backup of the store to w; see BackupSince.
+func (kv *KV) Backup(w io.Writer) (*BackupInfo, error) {
+	return kv.BackupSince(w, nil)
+}
+
+// BackupContext is Backup, giving up with the error of ctx once it is done.
+func (kv *KV) BackupContext(ctx context.Context, w io.Writer) (*BackupInfo, error) {
+	return kv.BackupSinceContext(ctx, w, nil)
+}
+
+// BackupSince writes a backup of the store to w that holds what was committed
+// since the backup described by since was taken. If since is nil, or a
+// compaction rewrote the files in the meantime, a full backup is written
+// instead; the Since field of the returned BackupInfo tells which.
+//
+// The memtable is flushed first, so the backup holds every write made before
+// BackupSince was called. The files are then pinned as of that flush and
+// copied while the store keeps serving reads and writes. Backups cannot be
+// taken from a store opened read-only.
+func (kv *KV) BackupSince(w io.Writer, since *BackupInfo) (*BackupInfo, error) {
+	return kv.BackupSinceContext(context.Background(), w, since)
+}
+
+// BackupSinceContext is BackupSince, giving up with the error of ctx once it
+// is done. What was written to w by then is not a valid backup.
+func (kv *KV) BackupSinceContext(ctx context.Context, w io.Writer, since *BackupInfo) (*BackupInfo, error) {
+	if kv.readOnly {
+		return nil, ErrReadOnly
+	}
+	if kv.inMemory {
+		return nil, ErrInMemory
+	}
+
+	if err := kv.SyncToDisk(); err != nil {
+		return nil, err
+	}
+
+	sources, release, err := kv.pinBackup()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	info := &BackupInfo{Blobs: make(map[uint64]BackupFile)}
+	files := make(map[string]*BackupFile)
+
+	for _, s := range sources {
+		tail, err := tailChecksum(s.r, s.size)
+		if err != nil {
+			return nil, err
+		}
+		f := BackupFile{Size: s.size, Tail: tail}
+
+		switch s.name {
+		case backupDataEntry:
+			info.Data = f
+		case backupIndexEntry:
+			info.Index = f
+		default:
+			info.Blobs[blobEntryFile(s.name)] = f
+		}
+	}
+
+	if since != nil {
+		ok, err := backupContinues(since, sources)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			info.Since = &BackupInfo{Data: since.Data, Index: since.Index, Blobs: since.Blobs}
+			files[backupDataEntry] = &info.Since.Data
+			files[backupIndexEntry] = &info.Since.Index
+			for file := range since.Blobs {
+				f := since.Blobs[file]
+				files[blobEntryName(file)] = &f
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(&contextWriter{ctx: ctx, w: w})
+
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBackupEntry(tw, backupInfoEntry, bytes.NewReader(payload), int64(len(payload))); err != nil {
+		return nil, err
+	}
+
+	for _, s := range sources {
+		from := int64(0)
+		if f, ok := files[s.name]; ok {
+			from = f.Size
+		}
+
+		if err := writeBackupEntry(tw, s.name, io.NewSectionReader(s.r, from, s.size-from), s.size-from); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// contextWriter fails writes with the error of ctx once it is done, so that
+// copying large files stops soon after.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// pinBackup pins the data, index and blob files as of the last commit. Later
+// flushes only append past the returned sizes and compactions replace the
+// files rather than rewrite them, so the pinned bytes do not change.
+func (kv *KV) pinBackup() ([]backupSource, func(), error) {
+	kv.flushLock.Lock()
+	defer kv.flushLock.Unlock()
+
+	var handles []*readHandle
+	var index *os.File
+
+	release := func() {
+		for _, h := range handles {
+			kv.files.release(h)
+		}
+		if index != nil {
+			index.Close()
+		}
+	}
+
+	h, err := kv.files.acquire(kv.dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	handles = append(handles, h)
+
+	sources := []backupSource{{name: backupDataEntry, r: h, size: kv.offset}}
+
+	index, err = os.Open(kv.indexPath)
+	switch {
+	case os.IsNotExist(err) && kv.indexOffset == 0:
+		// Nothing was flushed yet.
+		sources = append(sources, backupSource{name: backupIndexEntry, r: strings.NewReader(""), size: 0})
+	case err != nil:
+		release()
+		return nil, nil, err
+	default:
+		sources = append(sources, backupSource{name: backupIndexEntry, r: index, size: kv.indexOffset})
+	}
+
+	for _, file := range kv.blobFiles() {
+		bh, err := kv.files.acquire(kv.blobPath(file))
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		handles = append(handles, bh)
+
+		// Every blob file is synced and closed by the flush that wrote to
+		// it, so its size is the committed one.
+		st, err := bh.file.Stat()
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+
+		sources = append(sources, backupSource{name: blobEntryName(file), r: bh, size: st.Size()})
+	}
+
+	return sources, release, nil
+}
+
+// backupContinues reports whether every file still holds the bytes since
+// was taken of, so that an incremental backup can be taken on top of it.
+func backupContinues(since *BackupInfo, sources []backupSource) (bool, error) {
+	for _, s := range sources {
+		var f BackupFile
+
+		switch s.name {
+		case backupDataEntry:
+			f = since.Data
+		case backupIndexEntry:
+			f = since.Index
+		default:
+			var ok bool
+			if f, ok = since.Blobs[blobEntryFile(s.name)]; !ok {
+				// Started after since was taken.
+				continue
+			}
+		}
+
+		if f.Size > s.size {
+			return false, nil
+		}
+
+		tail, err := tailChecksum(s.r, f.Size)
+		if err != nil {
+			return false, err
+		}
+		if tail != f.Tail {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// tailChecksum returns the checksum of the last bytes of r before size.
+func tailChecksum(r io.ReaderAt, size int64) (uint32, error) {
+	from := size - backupTailSize
+	if from < 0 {
+		from = 0
+	}
+
+	data := make([]byte, size-from)
+	if _, err := r.ReadAt(data, from); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	return crc32.ChecksumIEEE(data), nil
+}
+
+func writeBackupEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func blobEntryName(file uint64) string {
+	return fmt.Sprintf("%s%06d", backupBlobPrefix, file)
+}
+
+func blobEntryFile(name string) uint64 {
+	var file uint64
+	fmt.Sscanf(strings.TrimPrefix(name, backupBlobPrefix), "%d", &file)
+	return file
+}
+
+// BackupTo backs the store up into dir as a store that can be opened with
+// the same file names. If dir already holds a backup taken with BackupTo,
+// only what was committed since is copied.
+func (kv *KV) BackupTo(dir string) (*BackupInfo, error) {
+	if kv.inMemory {
+		return nil, ErrInMemory
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDirExclusive(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+
+	target := &KV{
+		dbPath:    filepath.Join(dir, filepath.Base(kv.dbPath)),
+		indexPath: filepath.Join(dir, filepath.Base(kv.indexPath)),
+	}
+	infoPath := filepath.Join(dir, backupInfoEntry)
+
+	var since *BackupInfo
+	if data, err := ioutil.ReadFile(infoPath); err == nil {
+		since = new(BackupInfo)
+		if err := json.Unmarshal(data, since); err != nil {
+			return nil, err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := target.restoreBackup(pr, since)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	info, err := kv.BackupSince(pw, since)
+	pw.CloseWithError(err)
+
+	if rerr := <-done; err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := target.writeRestoredManifest(info); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSynced(infoPath, data); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Restore creates a store at dbPath and indexPath from the given backups: a
+// full backup followed by any number of incremental backups, each taken on
+// top of the one before it. The store must not exist yet.
+func Restore(dbPath, indexPath string, backups ...io.Reader) error {
+	if fileExists(dbPath) {
+		return fmt.Errorf("kv: %s already exists", dbPath)
+	}
+
+	lock, err := lockDirExclusive(filepath.Dir(dbPath))
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	target := &KV{dbPath: dbPath, indexPath: indexPath}
+
+	var info *BackupInfo
+	for _, r := range backups {
+		if info, err = target.restoreBackup(r, info); err != nil {
+			target.removeRestored()
+			return err
+		}
+	}
+
+	if info == nil {
+		return errors.New("kv: no backup to restore")
+	}
+
+	if err := target.writeRestoredManifest(info); err != nil {
+		target.removeRestored()
+		return err
+	}
+
+	return nil
+}
+
+// restoreBackup applies the backup read from r to the files of kv, which
+// hold the backup described by current, if any, and returns the BackupInfo of
+// the result. A full backup replaces whatever the files held.
+func (kv *KV) restoreBackup(r io.Reader, current *BackupInfo) (*BackupInfo, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != backupInfoEntry {
+		return nil, fmt.Errorf("kv: not a backup, found %q instead of %s", hdr.Name, backupInfoEntry)
+	}
+
+	info := new(BackupInfo)
+	if err := json.NewDecoder(tr).Decode(info); err != nil {
+		return nil, err
+	}
+
+	if info.Since != nil && (current == nil || !sameBackupFiles(info.Since, current)) {
+		return nil, ErrBackupMismatch
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var path string
+		var from int64
+		var want BackupFile
+
+		switch {
+		case hdr.Name == backupDataEntry:
+			path, want = kv.dbPath, info.Data
+			if info.Since != nil {
+				from = info.Since.Data.Size
+			}
+		case hdr.Name == backupIndexEntry:
+			path, want = kv.indexPath, info.Index
+			if info.Since != nil {
+				from = info.Since.Index.Size
+			}
+		case strings.HasPrefix(hdr.Name, backupBlobPrefix):
+			file := blobEntryFile(hdr.Name)
+			path, want = kv.blobPath(file), info.Blobs[file]
+			if info.Since != nil {
+				from = info.Since.Blobs[file].Size
+			}
+		default:
+			return nil, fmt.Errorf("kv: unexpected entry %q in backup", hdr.Name)
+		}
+
+		if from+hdr.Size != want.Size {
+			return nil, fmt.Errorf("kv: entry %q of backup holds %d bytes, expected %d", hdr.Name, hdr.Size, want.Size-from)
+		}
+
+		if err := restoreFile(path, from, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Blob files dropped since the previous backup.
+	for _, file := range kv.findBlobFiles() {
+		if _, ok := info.Blobs[file]; !ok {
+			if err := os.Remove(kv.blobPath(file)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// sameBackupFiles reports whether a and b describe the same file contents.
+func sameBackupFiles(a, b *BackupInfo) bool {
+	if a.Data != b.Data || a.Index != b.Index || len(a.Blobs) != len(b.Blobs) {
+		return false
+	}
+
+	for file, f := range a.Blobs {
+		if b.Blobs[file] != f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// restoreFile writes the bytes read from r to path from offset from on,
+// dropping whatever the file held past from.
+func restoreFile(path string, from int64, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(from); err != nil {
+		return err
+	}
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// writeRestoredManifest records the restored files as the first version of
+// the store.
+func (kv *KV) writeRestoredManifest(info *BackupInfo) error {
+	blobs := make([]uint64, 0, len(info.Blobs))
+	for file := range info.Blobs {
+		blobs = append(blobs, file)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i] < blobs[j] })
+
+	m, err := createManifest(kv.dbPath+manifestSuffix, manifestVersion{
+		Version:   1,
+		DataFile:  filepath.Base(kv.dbPath),
+		DataSize:  info.Data.Size,
+		IndexFile: filepath.Base(kv.indexPath),
+		IndexSize: info.Index.Size,
+		BlobFiles: blobs,
+	})
+	if err != nil {
+		return err
+	}
+	m.close()
+
+	return nil
+}
+
+// removeRestored removes the files written by a restore that failed.
+func (kv *KV) removeRestored() {
+	paths := []string{kv.dbPath, kv.indexPath, kv.dbPath + manifestSuffix}
+	for _, file := range kv.findBlobFiles() {
+		paths = append(paths, kv.blobPath(file))
+	}
+
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func setRange(store *KV, from, to int) {
+	for i := from; i < to; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+		store.Set(fmt.Sprintf("large_%d", i), largeValue(i, 2048))
+	}
+}
+
+func checkRange(t *testing.T, store *KV, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+
+		value, _ = store.Get(fmt.Sprintf("large_%d", i))
+		assetEqual(t, fmt.Sprintf("large_%d", i), largeValue(i, 2048), value)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+	restoreDir, _ := ioutil.TempDir("", "testRestore")
+	defer os.RemoveAll(restoreDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	store := NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024))
+	defer store.Close()
+	setRange(store, 0, N)
+
+	// Writes and compactions keep going while the backup is taken.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		setRange(store, N, 2*N)
+		store.CompactData()
+	}()
+
+	buf := bytes.NewBuffer([]byte{})
+	info, err := store.Backup(buf)
+	assetEqual(t, "err", nil, err)
+	if info.Since != nil {
+		t.Errorf("Expected a full backup\n")
+	}
+	wg.Wait()
+
+	restoredDB := filepath.Join(restoreDir, "data.db")
+	restoredIndex := filepath.Join(restoreDir, "indexes.idx")
+
+	err = Restore(restoredDB, restoredIndex, bytes.NewReader(buf.Bytes()))
+	assetEqual(t, "err", nil, err)
+
+	err = Restore(restoredDB, restoredIndex, bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Errorf("Expected an error restoring over an existing store\n")
+	}
+
+	restored := NewKV(restoredDB, restoredIndex, 1000, 10, WithValueThreshold(1024))
+	defer restored.Close()
+	checkRange(t, restored, 0, N)
+
+	report, err := Check(restoredDB, restoredIndex)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+	restoreDir, _ := ioutil.TempDir("", "testRestore")
+	defer os.RemoveAll(restoreDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	store := NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024), WithMaxBlobFileSize(64<<10))
+	defer store.Close()
+	setRange(store, 0, N)
+
+	full := bytes.NewBuffer([]byte{})
+	fullInfo, err := store.Backup(full)
+	assetEqual(t, "err", nil, err)
+
+	setRange(store, N, N+10)
+
+	incremental := bytes.NewBuffer([]byte{})
+	info, err := store.BackupSince(incremental, fullInfo)
+	assetEqual(t, "err", nil, err)
+	if info.Since == nil {
+		t.Errorf("Expected an incremental backup\n")
+	}
+	if incremental.Len() >= full.Len()/2 {
+		t.Errorf("Expected the incremental backup to be smaller. Got `%d` bytes, full is `%d`\n", incremental.Len(), full.Len())
+	}
+
+	err = Restore(filepath.Join(restoreDir, "data.db"), filepath.Join(restoreDir, "indexes.idx"), bytes.NewReader(incremental.Bytes()))
+	assetEqual(t, "without full", ErrBackupMismatch, err)
+	assetEqual(t, "cleaned up", false, fileExists(filepath.Join(restoreDir, "data.db")))
+
+	err = Restore(
+		filepath.Join(restoreDir, "data.db"), filepath.Join(restoreDir, "indexes.idx"),
+		bytes.NewReader(full.Bytes()), bytes.NewReader(incremental.Bytes()),
+	)
+	assetEqual(t, "err", nil, err)
+
+	restored := NewKV(filepath.Join(restoreDir, "data.db"), filepath.Join(restoreDir, "indexes.idx"), 1000, 10)
+	checkRange(t, restored, 0, N+10)
+	restored.Close()
+
+	// A compaction rewrites the files, so the next backup is a full one.
+	store.CompactData()
+
+	info, err = store.BackupSince(ioutil.Discard, info)
+	assetEqual(t, "err", nil, err)
+	if info.Since != nil {
+		t.Errorf("Expected a full backup after a compaction\n")
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+	backupDir, _ := ioutil.TempDir("", "testBackup")
+	defer os.RemoveAll(backupDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	store := NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024))
+	defer store.Close()
+
+	for round := 0; round < 3; round++ {
+		setRange(store, round*N, (round+1)*N)
+		if round == 1 {
+			store.CompactData()
+		}
+
+		info, err := store.BackupTo(backupDir)
+		assetEqual(t, "err", nil, err)
+		assetEqual(t, fmt.Sprintf("incremental %d", round), round == 2, info.Since != nil)
+
+		backup, err := OpenKV(filepath.Join(backupDir, "data.db"), filepath.Join(backupDir, "indexes.idx"), WithReadOnly())
+		assetEqual(t, "err", nil, err)
+		checkRange(t, backup, 0, (round+1)*N)
+		backup.Close()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -122,14 +123,14 @@ func benchmarkParallelGet(blockSize, numberOfKeys int, b *testing.B) {
 	store := NewKV(dbPath, indexPath, uint32(blockSize), 10)
 	defer store.Close()
 
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
+	b.SetBytes(int64(len([]byte(fmt.Sprintf("value_%d", numberOfKeys)))))
 	b.ResetTimer()
+	b.StartTimer()
 
 	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			b.StartTimer()
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
 
+		for pb.Next() {
 			index := r.Int31n(int32(numberOfKeys))
 			key := fmt.Sprintf("key_%d", index)
 			value, _ := store.Get(key)
@@ -139,11 +140,6 @@ func benchmarkParallelGet(blockSize, numberOfKeys int, b *testing.B) {
 					"Values mismatch `%s` expexted `%s`\n", value, fmt.Sprintf("value_%d", index),
 				)
 			}
-
-			b.SetBytes(int64(len([]byte(key))))
-			b.SetBytes(int64(len([]byte(value))))
-
-			b.StopTimer()
 		}
 	})
 }
@@ -160,22 +156,18 @@ func benchmarkParallelSet(blockSize, numberOfKeys int, b *testing.B) {
 	store := NewKV(dbPath, indexPath, uint32(blockSize), 10)
 	defer store.Close()
 
+	b.SetBytes(int64(len([]byte(fmt.Sprintf("value_%d", numberOfKeys)))))
 	b.ResetTimer()
+	b.StartTimer()
 
-	i := blockSize
+	i := int64(blockSize)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			b.StartTimer()
+			n := atomic.AddInt64(&i, 1)
 
-			key := fmt.Sprintf("key_%d", i)
-			value := fmt.Sprintf("value_%d", i)
+			key := fmt.Sprintf("key_%d", n)
+			value := fmt.Sprintf("value_%d", n)
 			store.Set(key, value)
-
-			b.SetBytes(int64(len([]byte(key))))
-			b.SetBytes(int64(len([]byte(value))))
-
-			b.StopTimer()
-			i = i + 1
 		}
 	})
 }
@@ -192,21 +184,17 @@ func benchmarkParallelDelete(blockSize, numberOfKeys int, b *testing.B) {
 	store := NewKV(dbPath, indexPath, uint32(blockSize), 10)
 	defer store.Close()
 
+	b.SetBytes(int64(len([]byte("__KVGO_TOMBSTONE__"))))
 	b.ResetTimer()
+	b.StartTimer()
 
-	i := blockSize
+	i := int64(blockSize)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			b.StartTimer()
+			n := atomic.AddInt64(&i, 1)
 
-			key := fmt.Sprintf("key_%d", i)
+			key := fmt.Sprintf("key_%d", n)
 			store.Delete(key)
-
-			b.SetBytes(int64(len([]byte(key))))
-			b.SetBytes(int64(len([]byte("__KVGO_TOMBSTONE__"))))
-
-			b.StopTimer()
-			i = i + 1
 		}
 	})
 }
@@ -259,50 +247,50 @@ func BenchmarkDelete_1000_500000(b *testing.B) {
 	benchmarkDelete(1000, 500000, b)
 }
 
-// func BenchmarkParallelGet_100_1000(b *testing.B) {
-// 	benchmarkParallelGet(100, 1000, b)
-// }
+func BenchmarkParallelGet_100_1000(b *testing.B) {
+	benchmarkParallelGet(100, 1000, b)
+}
 
-// func BenchmarkParallelGet_500_10000(b *testing.B) {
-// 	benchmarkParallelGet(500, 10000, b)
-// }
+func BenchmarkParallelGet_500_10000(b *testing.B) {
+	benchmarkParallelGet(500, 10000, b)
+}
 
-// func BenchmarkParallelGet_1000_100000(b *testing.B) {
-// 	benchmarkParallelGet(1000, 100000, b)
-// }
+func BenchmarkParallelGet_1000_100000(b *testing.B) {
+	benchmarkParallelGet(1000, 100000, b)
+}
 
-// func BenchmarkParallelGet_1000_500000(b *testing.B) {
-// 	benchmarkParallelGet(1000, 500000, b)
-// }
+func BenchmarkParallelGet_1000_500000(b *testing.B) {
+	benchmarkParallelGet(1000, 500000, b)
+}
 
-// func BenchmarkParallelSet_100_1000(b *testing.B) {
-// 	benchmarkParallelSet(100, 1000, b)
-// }
+func BenchmarkParallelSet_100_1000(b *testing.B) {
+	benchmarkParallelSet(100, 1000, b)
+}
 
-// func BenchmarkParallelSet_500_10000(b *testing.B) {
-// 	benchmarkParallelSet(500, 10000, b)
-// }
+func BenchmarkParallelSet_500_10000(b *testing.B) {
+	benchmarkParallelSet(500, 10000, b)
+}
 
-// func BenchmarkParallelSet_1000_100000(b *testing.B) {
-// 	benchmarkParallelSet(1000, 100000, b)
-// }
+func BenchmarkParallelSet_1000_100000(b *testing.B) {
+	benchmarkParallelSet(1000, 100000, b)
+}
 
-// func BenchmarkParallelSet_1000_500000(b *testing.B) {
-// 	benchmarkParallelSet(1000, 500000, b)
-// }
+func BenchmarkParallelSet_1000_500000(b *testing.B) {
+	benchmarkParallelSet(1000, 500000, b)
+}
 
-// func BenchmarkParallelDelete_100_1000(b *testing.B) {
-// 	benchmarkParallelDelete(100, 1000, b)
-// }
+func BenchmarkParallelDelete_100_1000(b *testing.B) {
+	benchmarkParallelDelete(100, 1000, b)
+}
 
-// func BenchmarkParallelDelete_500_10000(b *testing.B) {
-// 	benchmarkParallelDelete(500, 10000, b)
-// }
+func BenchmarkParallelDelete_500_10000(b *testing.B) {
+	benchmarkParallelDelete(500, 10000, b)
+}
 
-// func BenchmarkParallelDelete_1000_100000(b *testing.B) {
-// 	benchmarkParallelDelete(1000, 100000, b)
-// }
+func BenchmarkParallelDelete_1000_100000(b *testing.B) {
+	benchmarkParallelDelete(1000, 100000, b)
+}
 
-// func BenchmarkParallelDelete_1000_500000(b *testing.B) {
-// 	benchmarkParallelDelete(1000, 500000, b)
-// }
+func BenchmarkParallelDelete_1000_500000(b *testing.B) {
+	benchmarkParallelDelete(1000, 500000, b)
+}
@@ -0,0 +1,567 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Values of at least valueThreshold bytes are not stored in the data file.
+// They are appended to a blob file and the data file only keeps a pointer to
+// them, so compacting the data file copies the pointer instead of the value.
+// Blob files are numbered and only the newest one is appended to; a new one
+// is started once it grows past maxBlobFileSize. Blob files start with the
+// same header as data files and their records are laid out as:
+//
+//	crc32 (4) | flags (1) | value length (8) | value
+//
+// with the value compressed and encrypted like the value of a data record,
+// including being bound to its key.
+const (
+	DefaultValueThreshold  = 64 << 10
+	DefaultMaxBlobFileSize = 256 << 20
+
+	blobFileMagic        = "KVGB"
+	blobFileExt          = ".blob"
+	blobRecordHeaderSize = 13
+	blobPointerSize      = 24
+)
+
+// blobPointer locates a value in a blob file. length is the number of value
+// bytes stored, not counting the record header.
+type blobPointer struct {
+	file   uint64
+	offset int64
+	length int64
+}
+
+func (p blobPointer) encode() []byte {
+	b := make([]byte, blobPointerSize)
+	binary.BigEndian.PutUint64(b[:8], p.file)
+	binary.BigEndian.PutUint64(b[8:16], uint64(p.offset))
+	binary.BigEndian.PutUint64(b[16:], uint64(p.length))
+
+	return b
+}
+
+func decodeBlobPointer(b []byte) (blobPointer, error) {
+	if len(b) != blobPointerSize {
+		return blobPointer{}, ErrCorruptRecord
+	}
+
+	return blobPointer{
+		file:   binary.BigEndian.Uint64(b[:8]),
+		offset: int64(binary.BigEndian.Uint64(b[8:16])),
+		length: int64(binary.BigEndian.Uint64(b[16:])),
+	}, nil
+}
+
+// size returns the number of bytes the record p points to takes in its file.
+func (p blobPointer) size() int64 {
+	return blobRecordHeaderSize + p.length
+}
+
+func (kv *KV) blobPath(file uint64) string {
+	return fmt.Sprintf("%s.%06d%s", kv.dbPath, file, blobFileExt)
+}
+
+// blobFiles returns the numbers of the live blob files in ascending order.
+// The caller must hold flushLock.
+func (kv *KV) blobFiles() []uint64 {
+	return append([]uint64(nil), kv.blobFileList...)
+}
+
+// findBlobFiles returns the numbers of the blob files found on disk in
+// ascending order.
+func (kv *KV) findBlobFiles() []uint64 {
+	paths, err := filepath.Glob(kv.dbPath + ".*" + blobFileExt)
+	if err != nil {
+		log.Error("Error: ", err)
+		return nil
+	}
+
+	files := make([]uint64, 0, len(paths))
+	for _, path := range paths {
+		n := strings.TrimSuffix(strings.TrimPrefix(path, kv.dbPath+"."), blobFileExt)
+
+		if file, err := strconv.ParseUint(n, 10, 64); err == nil {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+
+	return files
+}
+
+// loadBlobFiles checks that every blob file can be decrypted and picks up
+// the newest one to keep appending to.
+func (kv *KV) loadBlobFiles() error {
+	for _, file := range kv.blobFileList {
+		path := kv.blobPath(file)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		format := readFormat(f, blobFileMagic)
+		st, err := f.Stat()
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+		if err := kv.checkKey(path, format); err != nil {
+			return err
+		}
+
+		kv.blobFile = file
+		kv.blobFormat = format
+		kv.blobOffset = st.Size()
+	}
+
+	return nil
+}
+
+// isBlobValue reports whether value is to be written to a blob file rather
+// than inline in a data file of format f.
+func (kv *KV) isBlobValue(f fileFormat, value string) bool {
+	return kv.valueThreshold > 0 &&
+		len(value) >= kv.valueThreshold &&
+		f.version != legacyVersion &&
+		value != "__KVGO_TOMBSTONE__"
+}
+
+// blobWriter appends values to the newest blob file. The caller must hold
+// flushLock for as long as it is used.
+type blobWriter struct {
+	kv      *KV
+	f       *os.File
+	written bool
+}
+
+func (kv *KV) newBlobWriter() *blobWriter {
+	return &blobWriter{kv: kv}
+}
+
+// open opens the newest blob file for appending, starting a new one if there
+// is none yet or it is full.
+func (w *blobWriter) open() error {
+	kv := w.kv
+
+	if w.f != nil && kv.blobOffset < kv.maxBlobFileSize {
+		return nil
+	}
+	w.close()
+
+	if kv.blobFile == 0 || kv.blobOffset >= kv.maxBlobFileSize || !kv.isLiveBlob(kv.blobFile) {
+		kv.blobFile++
+		kv.blobFormat = kv.newFormat()
+
+		header := fileHeader(blobFileMagic, kv.blobFormat.keyID)
+		if err := writeSynced(kv.blobPath(kv.blobFile), header); err != nil {
+			return err
+		}
+		kv.blobOffset = int64(len(header))
+		kv.blobFileList = append(kv.blobFileList, kv.blobFile)
+	}
+
+	f, err := os.OpenFile(kv.blobPath(kv.blobFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.f = f
+
+	return nil
+}
+
+// write appends the value of key to the newest blob file and returns where
+// it was stored along with the number of value bytes stored.
+func (w *blobWriter) write(key, value string) (blobPointer, int, error) {
+	if err := w.open(); err != nil {
+		return blobPointer{}, 0, err
+	}
+	kv := w.kv
+
+	flags, val := compressValue([]byte(value), kv.compression)
+
+	val, err := kv.blobFormat.seal(kv.keys, val, kv.blobFormat.binding([]byte(key)))
+	if err != nil {
+		return blobPointer{}, 0, err
+	}
+
+	header := make([]byte, blobRecordHeaderSize)
+	header[4] = flags
+	binary.BigEndian.PutUint64(header[5:], uint64(len(val)))
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(val)
+	binary.BigEndian.PutUint32(header[:4], crc.Sum32())
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(header)+len(val)))
+	buf.Write(header)
+	buf.Write(val)
+
+	if _, err := w.f.Write(buf.Bytes()); err != nil {
+		return blobPointer{}, 0, err
+	}
+	w.written = true
+	kv.countDiskWrite(buf.Len())
+
+	p := blobPointer{file: kv.blobFile, offset: kv.blobOffset, length: int64(len(val))}
+	kv.blobOffset += p.size()
+
+	return p, len(val), nil
+}
+
+func (w *blobWriter) close() error {
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Sync()
+	if err != nil {
+		log.Error("Error: ", err)
+	}
+	w.f.Close()
+	w.f = nil
+
+	if w.written && w.kv.mmap {
+		// Same as for the data file: have the appended values mapped on the
+		// next read.
+		w.kv.files.retire(w.kv.blobPath(w.kv.blobFile))
+	}
+
+	return err
+}
+
+// readBlob returns the value of key p points to.
+func (kv *KV) readBlob(key string, p blobPointer) (string, error) {
+	h, err := kv.files.acquire(kv.blobPath(p.file))
+	if err != nil {
+		return "", err
+	}
+	defer kv.files.release(h)
+
+	data := make([]byte, p.size())
+	if _, err := h.ReadAt(data, p.offset); err != nil {
+		return "", err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(data[4:])
+
+	if crc.Sum32() != binary.BigEndian.Uint32(data[:4]) ||
+		int64(binary.BigEndian.Uint64(data[5:blobRecordHeaderSize])) != p.length {
+		return "", ErrCorruptRecord
+	}
+
+	val, err := h.format.open(h.keys, data[blobRecordHeaderSize:], h.format.binding([]byte(key)))
+	if err != nil {
+		return "", err
+	}
+
+	if val, err = decompress(Compression(data[4]&compressionMask), val); err != nil {
+		return "", ErrCorruptRecord
+	}
+
+	return string(val), nil
+}
+
+func (kv *KV) isLiveBlob(file uint64) bool {
+	for _, f := range kv.blobFileList {
+		if f == file {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropBlobFiles takes the given blob files out of the live set recorded by
+// the next commit. The caller must hold flushLock.
+func (kv *KV) dropBlobFiles(files []uint64) {
+	dropped := make(map[uint64]bool, len(files))
+	for _, file := range files {
+		dropped[file] = true
+	}
+
+	live := kv.blobFileList[:0:0]
+	for _, file := range kv.blobFileList {
+		if !dropped[file] {
+			live = append(live, file)
+		}
+	}
+	kv.blobFileList = live
+}
+
+// removeBlobFiles deletes the given blob files once no reader can still be
+// following a pointer into them. They must have been dropped and committed.
+func (kv *KV) removeBlobFiles(files []uint64) {
+	if len(files) == 0 {
+		return
+	}
+
+	kv.blobLock.Lock()
+	defer kv.blobLock.Unlock()
+
+	for _, file := range files {
+		path := kv.blobPath(file)
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error("Error: ", err)
+		}
+		kv.files.retire(path)
+	}
+}
+
+// CompactBlobs reclaims the space taken in blob files by values that were
+// overwritten or deleted. Every blob file but the newest in which at least
+// discardRatio of the bytes are garbage has its live values moved to the
+// newest blob file and is then removed. Blob files encrypted with a key other
+// than the active one are rewritten regardless, so that a key rotation
+// eventually reaches them too.
+func (kv *KV) CompactBlobs(discardRatio float64) error {
+	return kv.CompactBlobsContext(context.Background(), discardRatio)
+}
+
+// CompactBlobsContext is CompactBlobs, abandoning the compaction with the
+// error of ctx once it is done. Values already moved stay where they were
+// moved to and the blob files they were moved from are kept.
+func (kv *KV) CompactBlobsContext(ctx context.Context, discardRatio float64) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	if kv.inMemory {
+		return nil
+	}
+
+	return kv.compactBlobs(ctx, discardRatio)
+}
+
+// compactBlobs returns the error the compaction ran into, if any.
+func (kv *KV) compactBlobs(ctx context.Context, discardRatio float64) error {
+	kv.flushLock.Lock()
+	defer kv.flushLock.Unlock()
+
+	start := time.Now()
+	info := CompactionInfo{Blobs: true, StartTime: start}
+	kv.notifyCompactionBegin(info)
+	defer func() {
+		info.Duration = time.Since(start)
+		kv.notifyCompactionEnd(info)
+	}()
+
+	fail := func(err error) {
+		info.Err = err
+		kv.backgroundError("blob compaction", err)
+	}
+
+	kv.lock.RLock()
+	current := make(map[string]Index, len(kv.index))
+	for k, v := range kv.index {
+		current[k] = v
+	}
+	h, err := kv.files.acquire(kv.dbPath)
+	kv.lock.RUnlock()
+
+	if err != nil {
+		fail(err)
+		return info.Err
+	}
+	defer kv.files.release(h)
+
+	live := make(map[uint64]int64)
+	refs := make(map[uint64]map[string]blobPointer)
+
+	for k, indexVal := range current {
+		if err := ctx.Err(); err != nil {
+			info.Err = err
+			return err
+		}
+
+		val, flags, err := readKeyRecord(h, k, indexVal.Offset)
+		if err != nil {
+			kv.corruption(kv.dbPath, indexVal.Offset, err)
+			fail(err)
+			return info.Err
+		}
+		if flags&blobPointerFlag == 0 {
+			continue
+		}
+
+		p, err := decodeBlobPointer(val)
+		if err != nil {
+			fail(err)
+			return info.Err
+		}
+
+		if refs[p.file] == nil {
+			refs[p.file] = make(map[string]blobPointer)
+		}
+		refs[p.file][k] = p
+		live[p.file] += p.size()
+	}
+
+	var garbage []uint64
+
+	for _, file := range kv.blobFiles() {
+		if file == kv.blobFile {
+			continue
+		}
+
+		f, err := os.Open(kv.blobPath(file))
+		if err != nil {
+			fail(err)
+			continue
+		}
+		format := readFormat(f, blobFileMagic)
+		st, err := f.Stat()
+		f.Close()
+
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		size := st.Size() - fileHeaderSize
+		if size <= 0 || float64(size-live[file])/float64(size) >= discardRatio || format.keyID != kv.keys.ActiveKey() {
+			garbage = append(garbage, file)
+			info.InputBytes += st.Size()
+		}
+	}
+
+	if len(garbage) == 0 {
+		return info.Err
+	}
+
+	f, err := os.OpenFile(kv.dbPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fail(err)
+		return info.Err
+	}
+	defer f.Close()
+
+	w := kv.newBlobWriter()
+	defer w.close()
+	dataOffset := kv.offset
+	written := atomic.LoadInt64(&kv.diskBytes)
+
+	memIndex := make(map[string]Index)
+	buf := bytes.NewBuffer([]byte{})
+
+	cancelled := false
+
+moving:
+	for _, file := range garbage {
+		for k, p := range refs[file] {
+			if err := ctx.Err(); err != nil {
+				info.Err = err
+				cancelled = true
+				break moving
+			}
+
+			v, err := kv.readBlob(k, p)
+			if err != nil {
+				fail(err)
+				return info.Err
+			}
+
+			moved, _, err := w.write(k, v)
+			if err != nil {
+				fail(err)
+				return info.Err
+			}
+
+			buf.Reset()
+			if err := appendPointerRecord(buf, kv.format, kv.keys, k, moved); err != nil {
+				fail(err)
+				return info.Err
+			}
+
+			if _, err := f.Write(buf.Bytes()); err != nil {
+				fail(err)
+				return info.Err
+			}
+
+			memIndex[k] = Index{kv.offset}
+			kv.offset += int64(buf.Len())
+			kv.countDiskWrite(buf.Len())
+			info.Keys++
+		}
+	}
+	if err := w.close(); err != nil {
+		fail(err)
+		return info.Err
+	}
+
+	if kv.mmap {
+		kv.files.retire(kv.dbPath)
+	}
+
+	if err := kv.syncData(f, kv.offset-dataOffset); err != nil {
+		fail(err)
+		return info.Err
+	}
+
+	// The values moved so far are committed either way, but after a
+	// cancellation the files they were moved from may still hold others.
+	if cancelled {
+		garbage = nil
+	}
+
+	if err := kv.syncMemIndexToDisk(memIndex); err != nil {
+		fail(err)
+		return info.Err
+	}
+	files := kv.blobFileList
+	kv.dropBlobFiles(garbage)
+	if err := kv.commit(); err != nil {
+		// The records that were not moved still point into the garbage.
+		kv.blobFileList = files
+		fail(err)
+		return info.Err
+	}
+
+	// The index cannot have changed meanwhile as everything that writes to
+	// it holds flushLock.
+	kv.lock.Lock()
+	for k, v := range memIndex {
+		kv.index[k] = v
+	}
+	kv.lock.Unlock()
+
+	kv.removeBlobFiles(garbage)
+
+	info.OutputBytes = atomic.LoadInt64(&kv.diskBytes) - written
+	info.BlobFilesRemoved = len(garbage)
+
+	return info.Err
+}
+
+// writeSynced writes data to a new file at path and syncs it.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func largeValue(i, size int) string {
+	return strings.Repeat(fmt.Sprintf("%08d", i), size/8)
+}
+
+func fileSize(path string) int64 {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return st.Size()
+}
+
+func TestBlobValues(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 20
+	store := NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024), WithCacheSize(0))
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("large_%d", i), largeValue(i, 4096))
+		store.Set(fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.Close()
+
+	if size := fileSize(dbPath); size > 4096 {
+		t.Errorf("Expected large values to be kept out of the data file. Got `%d` bytes\n", size)
+	}
+	if size := fileSize(dbPath + ".000001.blob"); size < int64(N*4096) {
+		t.Errorf("Expected large values in the blob file. Got `%d` bytes\n", size)
+	}
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024), WithCacheSize(0))
+	defer store.Close()
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("large_%d", i))
+		assetEqual(t, fmt.Sprintf("large_%d", i), largeValue(i, 4096), value)
+
+		value, _ = store.Get(fmt.Sprintf("small_%d", i))
+		assetEqual(t, fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+
+	items := store.Items()
+	assetEqual(t, "items", 2*N, len(items))
+	assetEqual(t, "large_0", largeValue(0, 4096), items["large_0"])
+}
+
+func TestCompactDataKeepsBlobs(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 20
+	store := NewKV(dbPath, indexPath, 1000, 10, WithValueThreshold(1024), WithMaxBlobFileSize(16<<10), WithCacheSize(0))
+	defer store.Close()
+
+	// Syncing every value lays them out in order, four to a blob file.
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), largeValue(i, 4096))
+		store.SyncToDisk()
+	}
+
+	blobs := len(store.blobFiles())
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+	store.CompactData()
+
+	assetEqual(t, "blob files", blobs, len(store.blobFiles()))
+
+	// Deleting every value of the first blob file makes it garbage.
+	for i := 0; i < 4; i++ {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.SyncToDisk()
+	store.CompactData()
+
+	assetEqual(t, "blob files", blobs-1, len(store.blobFiles()))
+
+	for i := 4; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), largeValue(i, 4096), value)
+	}
+}
+
+func TestCompactBlobs(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 20
+	opts := []Option{WithValueThreshold(1024), WithMaxBlobFileSize(16 << 10), WithCacheSize(0)}
+	store := NewKV(dbPath, indexPath, 1000, 10, opts...)
+
+	// Syncing every value lays them out in order, four to a blob file.
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), largeValue(i, 4096))
+		store.SyncToDisk()
+	}
+
+	// Overwrite every other value so each of the full blob files is half
+	// garbage.
+	for i := 0; i < N; i += 2 {
+		store.Set(fmt.Sprintf("key_%d", i), largeValue(i+N, 4096))
+		store.SyncToDisk()
+	}
+
+	before := store.blobFiles()
+
+	store.CompactBlobs(0.75)
+	assetEqual(t, "blob files", len(before), len(store.blobFiles()))
+
+	store.CompactBlobs(0.5)
+
+	for _, file := range store.blobFiles() {
+		if file <= uint64(N/4) {
+			t.Errorf("Expected blob file %d to be removed\n", file)
+		}
+	}
+
+	check := func(store *KV) {
+		t.Helper()
+
+		for i := 0; i < N; i++ {
+			expected := largeValue(i, 4096)
+			if i%2 == 0 {
+				expected = largeValue(i+N, 4096)
+			}
+
+			value, _ := store.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("key_%d", i), expected, value)
+		}
+	}
+
+	check(store)
+	store.Close()
+
+	store = NewKV(dbPath, indexPath, 1000, 10, opts...)
+	defer store.Close()
+
+	check(store)
+}
+
+func TestEncryptedBlobs(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	ring, _ := ParseKeyRing(testKey1)
+	value := strings.Repeat("secret", 1000)
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithValueThreshold(1024))
+	store.Set("key", value)
+	store.Close()
+
+	data, _ := ioutil.ReadFile(dbPath + ".000001.blob")
+	assetEqual(t, "leak", false, bytes.Contains(data, []byte("secret")))
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithValueThreshold(1024))
+	defer store.Close()
+
+	v, _ := store.Get("key")
+	assetEqual(t, "key", value, v)
+}
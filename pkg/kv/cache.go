@@ -0,0 +1,153 @@
+package kv
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultCacheSize   = 8 << 20
+	cacheShards        = 16
+	cacheEntryOverhead = 64
+)
+
+type cacheKey struct {
+	file   uint64
+	offset int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value string
+}
+
+type cacheShard struct {
+	lock     sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[cacheKey]*list.Element
+}
+
+// Cache is a sharded LRU cache of values read from data files. Entries are
+// keyed by the file and offset they were read from and the cache is bounded
+// by the total size of the cached values in bytes.
+type Cache struct {
+	shards [cacheShards]*cacheShard
+	hits   uint64
+	misses uint64
+}
+
+type CacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	Entries  int
+	Size     int64
+	Capacity int64
+}
+
+func NewCache(capacity int64) *Cache {
+	c := new(Cache)
+
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{
+			capacity: capacity / cacheShards,
+			ll:       list.New(),
+			items:    make(map[cacheKey]*list.Element),
+		}
+	}
+
+	return c
+}
+
+func (c *Cache) shard(k cacheKey) *cacheShard {
+	h := uint64(k.offset) ^ (k.file * 0x9E3779B97F4A7C15)
+	h ^= h >> 33
+	h *= 0xFF51AFD7ED558CCD
+	h ^= h >> 33
+
+	return c.shards[h%cacheShards]
+}
+
+func (c *Cache) Get(file uint64, offset int64) (string, bool) {
+	k := cacheKey{file, offset}
+	s := c.shard(k)
+
+	s.lock.Lock()
+	e, ok := s.items[k]
+	if ok {
+		s.ll.MoveToFront(e)
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return "", false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return e.Value.(*cacheEntry).value, true
+}
+
+func (c *Cache) Set(file uint64, offset int64, value string) {
+	k := cacheKey{file, offset}
+	s := c.shard(k)
+	cost := int64(len(value) + cacheEntryOverhead)
+
+	if cost > s.capacity {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.items[k]; ok {
+		s.size -= int64(len(e.Value.(*cacheEntry).value) + cacheEntryOverhead)
+		s.ll.Remove(e)
+	}
+
+	s.items[k] = s.ll.PushFront(&cacheEntry{key: k, value: value})
+	s.size += cost
+
+	for s.size > s.capacity {
+		s.removeElement(s.ll.Back())
+	}
+}
+
+// EvictFile drops every entry read from the given file. It is called when a
+// file is retired by compaction so stale offsets are never served.
+func (c *Cache) EvictFile(file uint64) {
+	for _, s := range c.shards {
+		s.lock.Lock()
+		for k, e := range s.items {
+			if k.file == file {
+				s.removeElement(e)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+
+	for _, s := range c.shards {
+		s.lock.Lock()
+		stats.Entries += len(s.items)
+		stats.Size += s.size
+		stats.Capacity += s.capacity
+		s.lock.Unlock()
+	}
+
+	return stats
+}
+
+func (s *cacheShard) removeElement(e *list.Element) {
+	entry := s.ll.Remove(e).(*cacheEntry)
+	delete(s.items, entry.key)
+	s.size -= int64(len(entry.value) + cacheEntryOverhead)
+}
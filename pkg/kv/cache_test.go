@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheBasic(t *testing.T) {
+	cache := NewCache(1 << 20)
+
+	_, ok := cache.Get(1, 0)
+	assetEqual(t, "miss", false, ok)
+
+	cache.Set(1, 0, "value_0")
+	cache.Set(1, 32, "value_1")
+
+	value, ok := cache.Get(1, 0)
+	assetEqual(t, "hit", true, ok)
+	assetEqual(t, "hit", "value_0", value)
+
+	_, ok = cache.Get(2, 0)
+	assetEqual(t, "other file", false, ok)
+
+	stats := cache.Stats()
+	assetEqual(t, "hits", uint64(1), stats.Hits)
+	assetEqual(t, "misses", uint64(2), stats.Misses)
+	assetEqual(t, "entries", 2, stats.Entries)
+}
+
+func TestCacheEviction(t *testing.T) {
+	capacity := int64(cacheShards * (cacheEntryOverhead + 100) * 4)
+	cache := NewCache(capacity)
+	N := 1000
+
+	for i := 0; i < N; i++ {
+		cache.Set(1, int64(i*100), fmt.Sprintf("%0100d", i))
+	}
+
+	stats := cache.Stats()
+	if stats.Size > capacity {
+		t.Errorf("Expected cache size to be at most `%v`. Got `%v`\n", capacity, stats.Size)
+	}
+	if stats.Entries >= N {
+		t.Errorf("Expected cache to evict entries. Got `%v` entries\n", stats.Entries)
+	}
+
+	value, ok := cache.Get(1, int64((N-1)*100))
+	assetEqual(t, "most recent", true, ok)
+	assetEqual(t, "most recent", fmt.Sprintf("%0100d", N-1), value)
+
+	cache.Set(1, 0, string(make([]byte, capacity)))
+	_, ok = cache.Get(1, 0)
+	assetEqual(t, "too large", false, ok)
+}
+
+func TestCacheEvictFile(t *testing.T) {
+	cache := NewCache(1 << 20)
+
+	for i := 0; i < 100; i++ {
+		cache.Set(1, int64(i), "old")
+		cache.Set(2, int64(i), "new")
+	}
+
+	cache.EvictFile(1)
+
+	for i := 0; i < 100; i++ {
+		_, ok := cache.Get(1, int64(i))
+		assetEqual(t, "evicted", false, ok)
+
+		value, _ := cache.Get(2, int64(i))
+		assetEqual(t, "kept", "new", value)
+	}
+}
+
+func TestCacheCompaction(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 10, 10, WithCacheSize(1<<20))
+	N := 100
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	for i := 0; i < N; i += 2 {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.SyncToDisk()
+
+	for i := 1; i < N; i += 2 {
+		store.Get(fmt.Sprintf("key_%d", i))
+	}
+	for i := 1; i < N; i += 2 {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+
+	if store.CacheStats().Hits == 0 {
+		t.Errorf("Expected cache hits after repeated reads\n")
+	}
+
+	store.CompactData()
+
+	assetEqual(t, "entries after compaction", 0, store.CacheStats().Entries)
+
+	for i := 0; i < N; i++ {
+		value, ok := store.Get(fmt.Sprintf("key_%d", i))
+		if i%2 == 0 {
+			assetEqual(t, fmt.Sprintf("key_%d", i), false, ok)
+		} else {
+			assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+		}
+	}
+}
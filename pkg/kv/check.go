@@ -0,0 +1,273 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckReport describes the problems Check found in the files of a store.
+// Offsets are byte offsets into the data file.
+type CheckReport struct {
+	// Records is the number of complete records in the data file.
+	Records int
+	// CorruptRecords are the offsets of records that fail their checksum or
+	// cannot be decoded, including pointers to unreadable blob values.
+	CorruptRecords []int64
+	// DataTail is the number of bytes at the end of the data file that do
+	// not make up a complete record.
+	DataTail int64
+
+	// IndexEntries is the number of complete entries in the index file.
+	IndexEntries int
+	// CorruptIndexEntries is the number of entries whose key cannot be read.
+	CorruptIndexEntries int
+	// IndexTail is the number of bytes at the end of the index file that do
+	// not make up a complete entry.
+	IndexTail int64
+
+	// Dangling are the keys whose index entry does not point at a readable
+	// record of that key.
+	Dangling []string
+	// Orphaned are the keys whose latest readable record is not the one the
+	// index points to.
+	Orphaned []string
+}
+
+// OK reports whether no problems were found.
+func (r *CheckReport) OK() bool {
+	return len(r.CorruptRecords) == 0 && r.DataTail == 0 &&
+		r.CorruptIndexEntries == 0 && r.IndexTail == 0 &&
+		len(r.Dangling) == 0 && len(r.Orphaned) == 0
+}
+
+// Check scans the data and index files of the store and validates the
+// framing, lengths and checksums of their records, and that the index points
+// at the latest record of every key. It only reads the files as of their
+// last commit, so it can run next to a writer.
+func Check(dbPath, indexPath string, opts ...Option) (*CheckReport, error) {
+	db, err := OpenKV(dbPath, indexPath, append(opts, WithReadOnly())...)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return db.check(db.pinned[0])
+}
+
+// Repair checks the store like Check and, if problems are found, truncates
+// the torn tail of the data file and rebuilds the index from it. Corrupt
+// records cannot be recovered; the index points at the previous readable
+// record of their key instead, if there is one. Repair needs the store to
+// itself and returns ErrLocked if a writer or a reader has it open.
+func Repair(dbPath, indexPath string, opts ...Option) (*CheckReport, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	db, err := OpenKV(dbPath, indexPath, append(opts, withMaintenance())...)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	db.flushLock.Lock()
+	defer db.flushLock.Unlock()
+
+	h, err := db.files.acquire(db.dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.files.release(h)
+
+	report, err := db.check(h)
+	if err != nil || report.OK() {
+		return report, err
+	}
+
+	return report, db.rebuildIndex(h)
+}
+
+// check scans the data file read by h and the index file up to their
+// current sizes.
+func (kv *KV) check(h *readHandle) (*CheckReport, error) {
+	report := &CheckReport{}
+
+	latest := make(map[string]int64)
+	readable := make(map[int64]string)
+
+	end := scanRecords(h, kv.offset, func(offset int64, key, val []byte, flags byte, err error) {
+		report.Records++
+
+		if err == nil && flags&blobPointerFlag != 0 {
+			err = kv.checkBlob(key, val)
+		}
+		if err != nil {
+			report.CorruptRecords = append(report.CorruptRecords, offset)
+			return
+		}
+
+		latest[string(key)] = offset
+		readable[offset] = string(key)
+	})
+	report.DataTail = kv.offset - end
+
+	index := make(map[string]int64)
+
+	f, err := os.Open(kv.indexPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+
+		if kv.indexOffset > 0 {
+			format := readFormat(f, indexFileMagic)
+
+			end := scanIndex(f, format, kv.keys, kv.indexOffset, func(key string, offset int64, err error) {
+				report.IndexEntries++
+
+				if err != nil {
+					report.CorruptIndexEntries++
+					return
+				}
+
+				index[key] = offset
+			})
+			report.IndexTail = kv.indexOffset - end
+		}
+	}
+
+	for key, offset := range index {
+		switch {
+		case readable[offset] != key:
+			report.Dangling = append(report.Dangling, key)
+		case latest[key] != offset:
+			report.Orphaned = append(report.Orphaned, key)
+		}
+	}
+	for key := range latest {
+		if _, ok := index[key]; !ok {
+			report.Orphaned = append(report.Orphaned, key)
+		}
+	}
+
+	sort.Strings(report.Dangling)
+	sort.Strings(report.Orphaned)
+
+	return report, nil
+}
+
+// checkBlob returns an error unless the encoded blobPointer val points at a
+// readable value of key in a live blob file.
+func (kv *KV) checkBlob(key, val []byte) error {
+	p, err := decodeBlobPointer(val)
+	if err != nil {
+		return err
+	}
+
+	if !kv.isLiveBlob(p.file) {
+		return ErrCorruptRecord
+	}
+
+	_, err = kv.readBlob(string(key), p)
+	return err
+}
+
+// indexFromData builds an index by scanning the data file read by h, in
+// which the last readable record of every key wins. It also returns the
+// offset the scan stopped at; anything past it is a torn tail.
+func (kv *KV) indexFromData(h *readHandle) (map[string]Index, int64) {
+	index := make(map[string]Index)
+
+	end := scanRecords(h, kv.offset, func(offset int64, key, val []byte, flags byte, err error) {
+		if err == nil && flags&blobPointerFlag != 0 {
+			err = kv.checkBlob(key, val)
+		}
+		if err != nil {
+			log.Warn("Skipping unreadable record at offset ", offset, " of ", kv.dbPath, ": ", err)
+			return
+		}
+
+		index[string(key)] = Index{offset}
+	})
+
+	return index, end
+}
+
+// indexBehind reports whether the index does not cover every record of the
+// data file read by h, as happens when the index file is lost, truncated or
+// replaced by a copy older than the data file. Every flush indexes all the
+// records it writes, so in a consistent store the last record of the data
+// file is always indexed.
+func (kv *KV) indexBehind(h *readHandle) bool {
+	last := int64(-1)
+	for _, v := range kv.index {
+		if v.Offset > last {
+			last = v.Offset
+		}
+	}
+
+	if last < 0 {
+		return kv.offset > h.format.headerSize()
+	}
+
+	size, err := recordSize(h, last, kv.offset)
+	return err != nil || last+size != kv.offset
+}
+
+// rebuildIndex replaces the index with one built by scanning the data file
+// read by h and writes it to a fresh index file. Bytes past the last
+// complete record are truncated. The caller must hold flushLock.
+func (kv *KV) rebuildIndex(h *readHandle) error {
+	index, end := kv.indexFromData(h)
+
+	format := kv.newFormat()
+
+	buf := bytes.NewBuffer(fileHeader(indexFileMagic, format.keyID))
+	for k, v := range index {
+		if err := appendIndexRecord(buf, format, kv.keys, k, v.Offset); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSynced(kv.indexPath+compactedSuffix, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// Like a compaction, the new index is committed before it is renamed into
+	// place. The data file is truncated after the commit so that a crash in
+	// between has it truncated on the next open.
+	if err := kv.commitAs(filepath.Base(kv.dbPath), filepath.Base(kv.indexPath+compactedSuffix), end, int64(buf.Len())); err != nil {
+		return err
+	}
+
+	if end < kv.offset {
+		log.Warn("Truncating ", kv.dbPath, " from ", kv.offset, " to ", end, " bytes")
+
+		if err := os.Truncate(kv.dbPath, end); err != nil {
+			return err
+		}
+	}
+
+	kv.lock.Lock()
+	kv.installCompacted(kv.indexPath)
+
+	kv.index = index
+	kv.offset = end
+	kv.indexOffset = int64(buf.Len())
+	kv.indexFormat = format
+
+	// Offsets past the truncation point will be reused by the next flush.
+	if kv.cache != nil {
+		kv.cache.EvictFile(kv.fileID)
+	}
+	kv.fileID++
+	kv.lock.Unlock()
+
+	return kv.commit()
+}
@@ -0,0 +1,195 @@
+package kv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckHealthyStore(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+	assetEqual(t, "records", N, report.Records)
+	assetEqual(t, "index entries", N, report.IndexEntries)
+
+	writer, err := OpenKV(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	defer writer.Close()
+
+	_, err = Check(dbPath, indexPath)
+	assetEqual(t, "check next to a writer", nil, err)
+
+	_, err = Repair(dbPath, indexPath)
+	assetEqual(t, "repair next to a writer", ErrLocked, err)
+}
+
+func TestCheckCorruptRecord(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+
+	// Flip a byte of the key of the first record.
+	f, _ := os.OpenFile(dbPath, os.O_RDWR, 0644)
+	f.WriteAt([]byte{0xff}, fileHeaderSize+recordHeaderSize)
+	f.Close()
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", false, report.OK())
+	assetEqual(t, "corrupt records", 1, len(report.CorruptRecords))
+	assetEqual(t, "corrupt offset", int64(fileHeaderSize), report.CorruptRecords[0])
+	assetEqual(t, "dangling", 1, len(report.Dangling))
+	assetEqual(t, "orphaned", 0, len(report.Orphaned))
+
+	report, err = Repair(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "dangling", 1, len(report.Dangling))
+
+	// The record is still corrupt, but the index no longer points at it.
+	report, err = Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "corrupt records", 1, len(report.CorruptRecords))
+	assetEqual(t, "dangling", 0, len(report.Dangling))
+	assetEqual(t, "index entries", N-1, report.IndexEntries)
+}
+
+func TestRepairTornTail(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+	size := fileSize(dbPath)
+
+	// Without a manifest nothing tells how much of the files was committed,
+	// so a half written record stays at the end of the data file.
+	os.Remove(dbPath + manifestSuffix)
+	f, _ := os.OpenFile(dbPath, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	f.Close()
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "data tail", int64(10), report.DataTail)
+
+	report, err = Repair(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "data tail", int64(10), report.DataTail)
+	assetEqual(t, "data size", size, fileSize(dbPath))
+
+	report, err = Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+
+	checkStore(t, dbPath, indexPath, N)
+}
+
+func TestRepairMissingIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+	os.Remove(indexPath)
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "orphaned", N, len(report.Orphaned))
+
+	_, err = Repair(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+
+	report, err = Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+	assetEqual(t, "index entries", N, report.IndexEntries)
+
+	checkStore(t, dbPath, indexPath, N)
+}
+
+func TestRebuildMissingIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+	os.Remove(indexPath)
+
+	reader, err := OpenKV(dbPath, indexPath, WithReadOnly())
+	assetEqual(t, "err", nil, err)
+	value, _ := reader.Get("key_0")
+	assetEqual(t, "read-only key_0", "value_0", value)
+	reader.Close()
+	assetEqual(t, "index written by reader", false, fileExists(indexPath))
+
+	checkStore(t, dbPath, indexPath, N)
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+	assetEqual(t, "index entries", N, report.IndexEntries)
+}
+
+func TestRebuildTruncatedIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N)
+	os.Truncate(indexPath, fileSize(indexPath)-5)
+
+	checkStore(t, dbPath, indexPath, N)
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+}
+
+func TestRebuildStaleIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 100
+	fillStore(dbPath, indexPath, N/2)
+	stale, _ := ioutil.ReadFile(indexPath)
+
+	fillStore(dbPath, indexPath, N)
+	ioutil.WriteFile(indexPath, stale, 0644)
+
+	checkStore(t, dbPath, indexPath, N)
+
+	report, err := Check(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, report.OK())
+}
@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// Codec converts the values of a Typed store to and from the strings kept in
+// the store.
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// GobCodec encodes values with encoding/gob. Every value carries its type
+// description, so it is larger than with the other codecs for small values.
+type GobCodec[V any] struct{}
+
+func (GobCodec[V]) Encode(value V) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	err := gob.NewEncoder(buf).Encode(value)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// MsgpackCodec encodes values with MessagePack.
+type MsgpackCodec[V any] struct{}
+
+var msgpackHandle = &codec.MsgpackHandle{}
+
+func (MsgpackCodec[V]) Encode(value V) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(value)
+	return data, err
+}
+
+func (MsgpackCodec[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&value)
+	return value, err
+}
+
+// ProtoCodec encodes protocol buffer messages. V is the pointer type of a
+// generated message, such as *pb.User.
+type ProtoCodec[V proto.Message] struct{}
+
+func (ProtoCodec[V]) Encode(value V) ([]byte, error) {
+	return proto.Marshal(value)
+}
+
+func (ProtoCodec[V]) Decode(data []byte) (V, error) {
+	// V is a pointer type, so the zero V is nil; a new message is made from
+	// the type it points to.
+	var zero V
+	value := reflect.New(reflect.TypeOf(zero).Elem()).Interface().(V)
+
+	err := proto.Unmarshal(data, value)
+	return value, err
+}
+
+// ErrKeyMismatch is returned by a KeyEncoder for keys it did not encode.
+var ErrKeyMismatch = errors.New("kv: key was not encoded by this key encoder")
+
+// KeyEncoder converts the keys of a Typed store to and from the strings kept
+// in the store. Typed stores iterate in the order of the encoded keys, so
+// encoders that keep the order of their keys make ordered iteration work.
+type KeyEncoder[K any] interface {
+	EncodeKey(key K) string
+	// DecodeKey returns ErrKeyMismatch for keys it could not have encoded.
+	DecodeKey(key string) (K, error)
+}
+
+// StringKey stores string keys as they are.
+type StringKey struct{}
+
+func (StringKey) EncodeKey(key string) string {
+	return key
+}
+
+func (StringKey) DecodeKey(key string) (string, error) {
+	return key, nil
+}
+
+// IntKey stores signed integer keys as 8 big-endian bytes with the sign bit
+// flipped, so that they sort in numeric order.
+type IntKey[K ~int | ~int8 | ~int16 | ~int32 | ~int64] struct{}
+
+func (IntKey[K]) EncodeKey(key K) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(int64(key))^(1<<63))
+	return string(b)
+}
+
+func (IntKey[K]) DecodeKey(key string) (K, error) {
+	if len(key) != 8 {
+		return 0, ErrKeyMismatch
+	}
+
+	return K(int64(binary.BigEndian.Uint64([]byte(key)) ^ (1 << 63))), nil
+}
+
+// UintKey stores unsigned integer keys as 8 big-endian bytes, so that they
+// sort in numeric order.
+type UintKey[K ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64] struct{}
+
+func (UintKey[K]) EncodeKey(key K) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(key))
+	return string(b)
+}
+
+func (UintKey[K]) DecodeKey(key string) (K, error) {
+	if len(key) != 8 {
+		return 0, ErrKeyMismatch
+	}
+
+	return K(binary.BigEndian.Uint64([]byte(key))), nil
+}
+
+// PrefixKey namespaces the keys of another encoder under Prefix, so that
+// several Typed stores can share a store.
+type PrefixKey[K any] struct {
+	Prefix string
+	Keys   KeyEncoder[K]
+}
+
+func (p PrefixKey[K]) EncodeKey(key K) string {
+	return p.Prefix + p.Keys.EncodeKey(key)
+}
+
+func (p PrefixKey[K]) DecodeKey(key string) (K, error) {
+	if len(key) < len(p.Prefix) || key[:len(p.Prefix)] != p.Prefix {
+		var zero K
+		return zero, ErrKeyMismatch
+	}
+
+	return p.Keys.DecodeKey(key[len(p.Prefix):])
+}
@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec used to compress values in the data file. The
+// codec is recorded in the flags of every record, so a file may mix records
+// written with different codecs. Snappy blocks are written and read with the
+// snappy compatible mode of s2.
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	SnappyCompression
+	ZstdCompression
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+}
+
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoCompression, nil
+	case "snappy":
+		return SnappyCompression, nil
+	case "zstd":
+		return ZstdCompression, nil
+	default:
+		return NoCompression, fmt.Errorf("unknown compression `%s`", name)
+	}
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case SnappyCompression:
+		return "snappy"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return fmt.Sprintf("compression(%d)", uint8(c))
+	}
+}
+
+func compress(c Compression, data []byte) []byte {
+	switch c {
+	case SnappyCompression:
+		return s2.EncodeSnappy(nil, data)
+	case ZstdCompression:
+		zstdOnce.Do(initZstd)
+		return zstdEncoder.EncodeAll(data, nil)
+	default:
+		return data
+	}
+}
+
+func decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case SnappyCompression:
+		return s2.Decode(nil, data)
+	case ZstdCompression:
+		zstdOnce.Do(initZstd)
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression %d", uint8(c))
+	}
+}
+
+// CompressionStats describes how much the values written since the store
+// was opened were compressed.
+type CompressionStats struct {
+	Compression Compression
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// Ratio returns how many times smaller the stored values are than the raw
+// ones.
+func (s CompressionStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
@@ -0,0 +1,222 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	value := []byte(strings.Repeat(`{"name":"value","tags":["a","b"]}`, 100))
+
+	for _, c := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+		decompressed, err := decompress(c, compress(c, value))
+
+		assetEqual(t, c.String(), nil, err)
+		assetEqual(t, c.String(), string(value), string(decompressed))
+	}
+}
+
+func TestSnappyBlock(t *testing.T) {
+	// "value value value value value value" as encoded by github.com/golang/snappy,
+	// which wrote the snappy records of existing stores.
+	block := []byte{0x23, 0x14, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x20, 0x72, 0x06, 0x00}
+
+	decompressed, err := decompress(SnappyCompression, block)
+
+	assetEqual(t, "snappy", nil, err)
+	assetEqual(t, "snappy", "value value value value value value", string(decompressed))
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+		parsed, err := ParseCompression(c.String())
+
+		assetEqual(t, c.String(), nil, err)
+		assetEqual(t, c.String(), c, parsed)
+	}
+
+	_, err := ParseCompression("lz4")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown codec\n")
+	}
+}
+
+func TestMixedCompression(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	codecs := []Compression{SnappyCompression, ZstdCompression, NoCompression}
+	N := 100
+
+	for j, c := range codecs {
+		store := NewKV(dbPath, indexPath, 1000, 10, WithCompression(c))
+
+		for i := 0; i < N; i++ {
+			store.Set(fmt.Sprintf("key_%d_%d", j, i), strings.Repeat(fmt.Sprintf("value_%d_%d", j, i), 20))
+		}
+		store.Close()
+
+		stats := store.CompressionStats()
+		if c != NoCompression && stats.Ratio() <= 1 {
+			t.Errorf("Expected %s to compress values. Got ratio `%v`\n", c, stats.Ratio())
+		}
+	}
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	for j := range codecs {
+		for i := 0; i < N; i++ {
+			value, _ := store.Get(fmt.Sprintf("key_%d_%d", j, i))
+			assetEqual(t, fmt.Sprintf("key_%d_%d", j, i), strings.Repeat(fmt.Sprintf("value_%d_%d", j, i), 20), value)
+		}
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	store.Set("key", "value")
+	store.Close()
+
+	data, _ := ioutil.ReadFile(dbPath)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(dbPath, data, 0644)
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	defer store.Close()
+
+	_, ok := store.Get("key")
+	assetEqual(t, "corrupt", false, ok)
+}
+
+func TestCorruptRecordLength(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	store.Set("key", "value")
+	store.Close()
+
+	// A record after it keeps the corrupt one from looking like a torn tail.
+	store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	store.Set("key2", "value2")
+	store.Close()
+
+	for _, length := range []uint64{1 << 62, 1 << 20} {
+		f, _ := os.OpenFile(dbPath, os.O_WRONLY, 0644)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, length)
+		f.WriteAt(b, fileHeaderSize+13)
+		f.Close()
+
+		store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+
+		_, ok := store.Get("key")
+		assetEqual(t, fmt.Sprintf("value length %d", length), false, ok)
+
+		val, _ := store.Get("key2")
+		assetEqual(t, fmt.Sprintf("value length %d", length), "value2", val)
+
+		store.Close()
+	}
+}
+
+func TestSnapshotCorruptRecord(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	store.Set("key", "value")
+	store.Close()
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	store.Set("key2", "value2")
+	store.Close()
+
+	// Flip the last byte of the value of the first record.
+	data, _ := ioutil.ReadFile(dbPath)
+	data[fileHeaderSize+recordHeaderSize+len("key")+len("value")-1] ^= 0xff
+	ioutil.WriteFile(dbPath, data, 0644)
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	defer store.Close()
+
+	items, err := store.Snapshot()
+	assetEqual(t, "snapshot error", ErrCorruptRecord, err)
+	assetEqual(t, "snapshot items", 0, len(items))
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err = store.Export(buf, BinaryDump)
+	assetEqual(t, "export error", ErrCorruptRecord, err)
+}
+
+func TestLegacyDataFile(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	data := bytes.NewBuffer([]byte{})
+	index := bytes.NewBuffer([]byte{})
+	N := 50
+
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key_%d", i)
+
+		binary.Write(index, binary.BigEndian, int64(len(k)))
+		binary.Write(index, binary.BigEndian, int64(data.Len()))
+		index.WriteString(k)
+
+		appendRecord(data, legacyFormat, nil, k, fmt.Sprintf("value_%d", i), NoCompression)
+	}
+	ioutil.WriteFile(dbPath, data.Bytes(), 0644)
+	ioutil.WriteFile(indexPath, index.Bytes(), 0644)
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithCompression(SnappyCompression))
+	defer store.Close()
+
+	assetEqual(t, "version", legacyVersion, store.format.version)
+
+	store.Set("key_0", "value_0_0")
+	store.SyncToDisk()
+
+	check := func() {
+		for i := 0; i < N; i++ {
+			expected := fmt.Sprintf("value_%d", i)
+			if i == 0 {
+				expected = "value_0_0"
+			}
+
+			value, _ := store.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("key_%d", i), expected, value)
+		}
+	}
+
+	check()
+	store.CompactData()
+
+	assetEqual(t, "version", currentVersion, store.format.version)
+	check()
+}
@@ -0,0 +1,414 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DumpFormat is the encoding of a logical dump of the keys and values of a
+// store. Unlike backups, dumps do not depend on the file format, the
+// compression or the encryption of the store they were taken from.
+type DumpFormat uint8
+
+const (
+	// JSONLines dumps hold one {"key":..,"value":..,"ttl":..} object per line.
+	// Keys and values that are not valid UTF-8 are base64 encoded and marked
+	// with "encoding":"base64". kvgo has no expiry, so ttl is always 0 in
+	// exports, and imports reject entries with another ttl unless their
+	// reader is given WithDroppedTTLs.
+	JSONLines DumpFormat = iota
+	// BinaryDump is a compact length-prefixed encoding.
+	BinaryDump
+)
+
+// Binary dumps start with a magic string and a version byte, followed by
+// entries laid out as:
+//
+//	1 (1) | key length (uvarint) | key | value length (uvarint) | value
+//
+// and end with a trailer that tells a complete dump from a truncated one:
+//
+//	0 (1) | entry count (uvarint) | crc32 of everything before it (4)
+const (
+	dumpMagic   = "KVGX"
+	dumpVersion = 1
+
+	dumpEntryTag   = 1
+	dumpTrailerTag = 0
+)
+
+var ErrCorruptDump = errors.New("kv: corrupt dump")
+
+// ErrUnsupportedTTL is returned for JSON Lines entries with a ttl, which
+// would be imported without ever expiring.
+var ErrUnsupportedTTL = errors.New("kv: entries with a ttl are not supported, as kvgo has no expiry")
+
+func ParseDumpFormat(name string) (DumpFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "jsonl", "json":
+		return JSONLines, nil
+	case "binary", "bin":
+		return BinaryDump, nil
+	default:
+		return JSONLines, fmt.Errorf("unknown dump format `%s`", name)
+	}
+}
+
+func (f DumpFormat) String() string {
+	switch f {
+	case JSONLines:
+		return "jsonl"
+	case BinaryDump:
+		return "binary"
+	default:
+		return fmt.Sprintf("dump(%d)", uint8(f))
+	}
+}
+
+type dumpEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	TTL      int64  `json:"ttl"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// DumpWriter writes a dump one entry at a time. Close must be called once
+// every entry is written.
+type DumpWriter struct {
+	w       *bufio.Writer
+	format  DumpFormat
+	crc     hash.Hash32
+	entries uint64
+	started bool
+}
+
+func NewDumpWriter(w io.Writer, format DumpFormat) *DumpWriter {
+	return &DumpWriter{w: bufio.NewWriter(w), format: format, crc: crc32.NewIEEE()}
+}
+
+// Write adds an entry to the dump.
+func (d *DumpWriter) Write(key, value string) error {
+	d.entries++
+
+	if d.format == JSONLines {
+		e := dumpEntry{Key: key, Value: value}
+		if !utf8.ValidString(key) || !utf8.ValidString(value) {
+			e.Key = base64.StdEncoding.EncodeToString([]byte(key))
+			e.Value = base64.StdEncoding.EncodeToString([]byte(value))
+			e.Encoding = "base64"
+		}
+
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		d.w.Write(line)
+		return d.w.WriteByte('\n')
+	}
+
+	if !d.started {
+		d.write([]byte(dumpMagic))
+		d.write([]byte{dumpVersion})
+		d.started = true
+	}
+
+	d.write([]byte{dumpEntryTag})
+	d.writeString(key)
+	d.writeString(value)
+
+	return nil
+}
+
+// Close ends the dump and flushes it to the underlying writer.
+func (d *DumpWriter) Close() error {
+	if d.format == BinaryDump {
+		if !d.started {
+			d.write([]byte(dumpMagic))
+			d.write([]byte{dumpVersion})
+		}
+
+		d.write([]byte{dumpTrailerTag})
+		d.write(binary.AppendUvarint(nil, d.entries))
+		binary.Write(d.w, binary.BigEndian, d.crc.Sum32())
+	}
+
+	return d.w.Flush()
+}
+
+func (d *DumpWriter) write(b []byte) {
+	d.crc.Write(b)
+	d.w.Write(b)
+}
+
+func (d *DumpWriter) writeString(s string) {
+	d.write(binary.AppendUvarint(nil, uint64(len(s))))
+	d.write([]byte(s))
+}
+
+// DumpReader reads a dump one entry at a time.
+type DumpReader struct {
+	r        *bufio.Reader
+	in       *checksumReader
+	format   DumpFormat
+	entries  uint64
+	started  bool
+	line     int
+	dropTTLs bool
+}
+
+// DumpOption configures optional behaviour of a DumpReader, and of the
+// imports and ingests that read a dump with one.
+type DumpOption func(*DumpReader)
+
+// WithDroppedTTLs imports entries with a ttl as if they had none, so that
+// they never expire, instead of failing with ErrUnsupportedTTL.
+func WithDroppedTTLs() DumpOption {
+	return func(d *DumpReader) {
+		d.dropTTLs = true
+	}
+}
+
+func NewDumpReader(r io.Reader, format DumpFormat, opts ...DumpOption) *DumpReader {
+	br := bufio.NewReader(r)
+	d := &DumpReader{r: br, in: &checksumReader{r: br, crc: crc32.NewIEEE()}, format: format}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Next returns the next entry of the dump, or io.EOF once all of them were
+// read. ErrCorruptDump is returned for binary dumps that are truncated or
+// fail their checksum, and an error wrapping ErrUnsupportedTTL for JSON Lines
+// entries with a ttl.
+func (d *DumpReader) Next() (string, string, error) {
+	if d.format == JSONLines {
+		return d.nextLine()
+	}
+
+	if !d.started {
+		header := make([]byte, len(dumpMagic)+1)
+		if _, err := io.ReadFull(d.in, header); err != nil || string(header[:len(dumpMagic)]) != dumpMagic {
+			return "", "", ErrCorruptDump
+		}
+		if header[len(dumpMagic)] != dumpVersion {
+			return "", "", fmt.Errorf("kv: unsupported dump version %d", header[len(dumpMagic)])
+		}
+		d.started = true
+	}
+
+	tag, err := d.in.ReadByte()
+	if err != nil {
+		return "", "", ErrCorruptDump
+	}
+
+	if tag == dumpTrailerTag {
+		count, err := binary.ReadUvarint(d.in)
+		if err != nil {
+			return "", "", ErrCorruptDump
+		}
+		crc := d.in.crc.Sum32()
+
+		var sum uint32
+		if err := binary.Read(d.r, binary.BigEndian, &sum); err != nil {
+			return "", "", ErrCorruptDump
+		}
+
+		if count != d.entries || sum != crc {
+			return "", "", ErrCorruptDump
+		}
+
+		return "", "", io.EOF
+	}
+	if tag != dumpEntryTag {
+		return "", "", ErrCorruptDump
+	}
+
+	key, err := d.readString()
+	if err != nil {
+		return "", "", err
+	}
+	value, err := d.readString()
+	if err != nil {
+		return "", "", err
+	}
+	d.entries++
+
+	return key, value, nil
+}
+
+func (d *DumpReader) nextLine() (string, string, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if len(line) > 0 {
+			d.line++
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			if err != nil {
+				return "", "", err
+			}
+			continue
+		}
+
+		var e dumpEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return "", "", err
+		}
+		if e.TTL != 0 && !d.dropTTLs {
+			return "", "", fmt.Errorf("line %d: %w", d.line, ErrUnsupportedTTL)
+		}
+
+		if e.Encoding == "base64" {
+			key, err := base64.StdEncoding.DecodeString(e.Key)
+			if err != nil {
+				return "", "", err
+			}
+			value, err := base64.StdEncoding.DecodeString(e.Value)
+			if err != nil {
+				return "", "", err
+			}
+			e.Key, e.Value = string(key), string(value)
+		}
+
+		return e.Key, e.Value, nil
+	}
+}
+
+func (d *DumpReader) readString() (string, error) {
+	length, err := binary.ReadUvarint(d.in)
+	if err != nil {
+		return "", ErrCorruptDump
+	}
+
+	// The length is not trusted to size the buffer up front.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.in, int64(length)); err != nil {
+		return "", ErrCorruptDump
+	}
+
+	return buf.String(), nil
+}
+
+// checksumReader keeps the checksum of everything read through it.
+type checksumReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *checksumReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.crc.Write(b[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// Export writes every live key and its value to w in the given format, in
+// key order, and returns the number of entries written.
+func (kv *KV) Export(w io.Writer, format DumpFormat) (int, error) {
+	return kv.ExportContext(context.Background(), w, format)
+}
+
+// ExportContext is Export, giving up with the error of ctx once it is done.
+func (kv *KV) ExportContext(ctx context.Context, w io.Writer, format DumpFormat) (int, error) {
+	return export(func(fn func(key, value string) bool) error {
+		return kv.IterateContext(ctx, fn)
+	}, w, format)
+}
+
+// ExportEngine writes every key and value of e to w like KV.Export.
+func ExportEngine(e Engine, w io.Writer, format DumpFormat) (int, error) {
+	return ExportEngineContext(context.Background(), e, w, format)
+}
+
+// ExportEngineContext is ExportEngine, giving up with the error of ctx once
+// it is done.
+func ExportEngineContext(ctx context.Context, e Engine, w io.Writer, format DumpFormat) (int, error) {
+	if db, ok := e.(*KV); ok {
+		return db.ExportContext(ctx, w, format)
+	}
+
+	return export(func(fn func(key, value string) bool) error {
+		err := e.Iterate(func(key, value string) bool {
+			return ctx.Err() == nil && fn(key, value)
+		})
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	}, w, format)
+}
+
+// export writes the entries iterate yields to w.
+func export(iterate func(fn func(key, value string) bool) error, w io.Writer, format DumpFormat) (int, error) {
+	d := NewDumpWriter(w, format)
+
+	n := 0
+	var writeErr error
+	err := iterate(func(key, value string) bool {
+		if writeErr = d.Write(key, value); writeErr != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if writeErr != nil {
+		return 0, writeErr
+	}
+
+	return n, d.Close()
+}
+
+// Import sets every entry of the dump read from r in the given format and
+// returns the number of entries set. Entries read before an error are kept.
+// opts configure how the dump is read; see DumpOption.
+func (kv *KV) Import(r io.Reader, format DumpFormat, opts ...DumpOption) (int, error) {
+	return kv.ImportContext(context.Background(), r, format, opts...)
+}
+
+// ImportContext is Import, stopping with the error of ctx once it is done.
+func (kv *KV) ImportContext(ctx context.Context, r io.Reader, format DumpFormat, opts ...DumpOption) (int, error) {
+	d := NewDumpReader(r, format, opts...)
+
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		key, value, err := d.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		if err := kv.Set(key, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,132 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDumpFormat(t *testing.T) {
+	for _, format := range []DumpFormat{JSONLines, BinaryDump} {
+		parsed, err := ParseDumpFormat(format.String())
+		assetEqual(t, "err", nil, err)
+		assetEqual(t, format.String(), format, parsed)
+	}
+
+	if _, err := ParseDumpFormat("xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format\n")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []DumpFormat{JSONLines, BinaryDump} {
+		tmpDir, _ := ioutil.TempDir("", "testStore")
+		defer os.RemoveAll(tmpDir)
+
+		src := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+
+		N := 100
+		for i := 0; i < N; i++ {
+			src.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+		}
+		src.Set("binary", "\xff\x00\xfe")
+		src.Set("deleted", "value")
+		src.Delete("deleted")
+
+		buf := bytes.NewBuffer([]byte{})
+		n, err := src.Export(buf, format)
+		assetEqual(t, "err", nil, err)
+		assetEqual(t, "exported", N+1, n)
+		src.Close()
+
+		dstDir, _ := ioutil.TempDir("", "testImport")
+		defer os.RemoveAll(dstDir)
+
+		dst := NewKV(filepath.Join(dstDir, "data.db"), filepath.Join(dstDir, "indexes.idx"), 1000, 10)
+
+		n, err = dst.Import(bytes.NewReader(buf.Bytes()), format)
+		assetEqual(t, "err", nil, err)
+		assetEqual(t, "imported", N+1, n)
+
+		for i := 0; i < N; i++ {
+			value, _ := dst.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("%s key_%d", format, i), fmt.Sprintf("value_%d", i), value)
+		}
+
+		value, _ := dst.Get("binary")
+		assetEqual(t, format.String()+" binary", "\xff\x00\xfe", value)
+
+		_, ok := dst.Get("deleted")
+		assetEqual(t, format.String()+" deleted", false, ok)
+
+		dst.Close()
+	}
+}
+
+func TestImportJSONLines(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	dump := `{"key":"a","value":"1","ttl":0}
+
+{"key":"b","value":"2","ttl":3600}
+{"key":"c","value":"3"}`
+
+	// kvgo has no expiry, so entries with a ttl are refused unless asked
+	// to be imported without it.
+	n, err := store.Import(strings.NewReader(dump), JSONLines)
+	assetEqual(t, "ttl", true, errors.Is(err, ErrUnsupportedTTL))
+	assetEqual(t, "ttl line", true, strings.Contains(err.Error(), "line 3"))
+	assetEqual(t, "imported before the ttl", 1, n)
+
+	n, err = store.Import(strings.NewReader(dump), JSONLines, WithDroppedTTLs())
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "imported", 3, n)
+
+	value, _ := store.Get("b")
+	assetEqual(t, "b", "2", value)
+
+	value, _ = store.Get("c")
+	assetEqual(t, "c", "3", value)
+
+	n, err = store.Import(strings.NewReader(`{"key":"d","value":"4"}`+"\nnot json\n"), JSONLines)
+	if err == nil {
+		t.Errorf("Expected an error for a malformed line\n")
+	}
+	assetEqual(t, "imported before the error", 1, n)
+}
+
+func TestImportTruncatedBinaryDump(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	buf := bytes.NewBuffer([]byte{})
+	d := NewDumpWriter(buf, BinaryDump)
+	for i := 0; i < 10; i++ {
+		d.Write(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	d.Close()
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	_, err := store.Import(bytes.NewReader(buf.Bytes()[:buf.Len()-10]), BinaryDump)
+	assetEqual(t, "truncated", ErrCorruptDump, err)
+
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt[10] ^= 0xff
+	_, err = store.Import(bytes.NewReader(corrupt), BinaryDump)
+	assetEqual(t, "corrupt", ErrCorruptDump, err)
+
+	n, err := store.Import(bytes.NewReader(buf.Bytes()), BinaryDump)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "imported", 10, n)
+}
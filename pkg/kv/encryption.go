@@ -0,0 +1,394 @@
+package kv
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	EncryptionKeysEnv = "KVGO_ENCRYPTION_KEYS"
+
+	envelopeMagic      = "KVGE"
+	envelopeHeaderSize = 8
+
+	streamMagic = "KVGS"
+	// streamChunkSize is the size of the chunks EncryptWriter seals data in.
+	streamChunkSize = 64 << 10
+	// streamFinalFlag marks the last chunk of a stream.
+	streamFinalFlag = 0x01
+)
+
+var ErrUnknownKey = errors.New("kv: unknown encryption key")
+
+// KeyRing holds AES keys by ID. New data is always encrypted with the active
+// key, while any key in the ring can be used to decrypt. Key ID 0 is reserved
+// and means the data is not encrypted.
+//
+// Keys are rotated by adding a new key and making it active: data files and
+// indexes keep the key they were created with until a compaction rewrites
+// them with the active key.
+type KeyRing struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[uint32]cipher.AEAD)}
+}
+
+// ParseKeyRing reads keys given as `<id>:<hex key>` pairs separated by
+// newlines or commas. Empty lines and lines starting with # are ignored. The
+// last key listed is the active one.
+func ParseKeyRing(text string) (*KeyRing, error) {
+	r := NewKeyRing()
+	scanner := bufio.NewScanner(strings.NewReader(strings.Replace(text, ",", "\n", -1)))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key `%s`: expected <id>:<hex key>", line)
+		}
+
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key id `%s`: %s", parts[0], err)
+		}
+
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d: %s", id, err)
+		}
+
+		if err := r.Add(uint32(id), key); err != nil {
+			return nil, err
+		}
+		r.active = uint32(id)
+	}
+
+	if len(r.keys) == 0 {
+		return nil, errors.New("no encryption keys given")
+	}
+
+	return r, nil
+}
+
+// LoadKeyRing reads a key ring from a key file in the ParseKeyRing format.
+func LoadKeyRing(path string) (*KeyRing, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKeyRing(string(data))
+}
+
+// KeyRingFromEnv reads a key ring from the KVGO_ENCRYPTION_KEYS environment
+// variable. It returns nil if the variable is not set.
+func KeyRingFromEnv() (*KeyRing, error) {
+	text := os.Getenv(EncryptionKeysEnv)
+	if text == "" {
+		return nil, nil
+	}
+
+	return ParseKeyRing(text)
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key to the ring under id.
+func (r *KeyRing) Add(id uint32, key []byte) error {
+	if id == 0 {
+		return errors.New("encryption key id 0 is reserved")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %d: %s", id, err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	r.keys[id] = aead
+
+	return nil
+}
+
+// SetActive makes the key with the given id the one used for new data.
+func (r *KeyRing) SetActive(id uint32) error {
+	if _, ok := r.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+
+	r.active = id
+
+	return nil
+}
+
+// ActiveKey returns the id of the key used for new data, or 0 for a nil
+// ring.
+func (r *KeyRing) ActiveKey() uint32 {
+	if r == nil {
+		return 0
+	}
+
+	return r.active
+}
+
+// seal encrypts data with the key id, prefixing it with a random nonce. aad
+// is authenticated along with data and must be passed again to open it.
+func (r *KeyRing) seal(id uint32, data, aad []byte) ([]byte, error) {
+	aead, ok := r.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, aad), nil
+}
+
+func (r *KeyRing) open(id uint32, data, aad []byte) ([]byte, error) {
+	if r == nil {
+		return nil, ErrUnknownKey
+	}
+
+	aead, ok := r.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, ErrCorruptRecord
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrCorruptRecord
+	}
+
+	return plain, nil
+}
+
+// Encrypt seals data with the active key into a self-describing envelope
+// that records the key id. It is used for data kept outside of the data and
+// index files, such as snapshots and replicated commands.
+func (r *KeyRing) Encrypt(data []byte) ([]byte, error) {
+	sealed, err := r.seal(r.active, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(sealed))
+	copy(envelope, envelopeMagic)
+	binary.BigEndian.PutUint32(envelope[4:], r.active)
+
+	return append(envelope, sealed...), nil
+}
+
+// Decrypt opens an envelope made by Encrypt. Data that is not an envelope is
+// returned as is, so plaintext written before encryption was enabled stays
+// readable.
+func (r *KeyRing) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+
+	return r.open(binary.BigEndian.Uint32(data[4:envelopeHeaderSize]), data[envelopeHeaderSize:], nil)
+}
+
+// IsEncrypted reports whether data is an envelope made by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return len(data) >= envelopeHeaderSize && string(data[:4]) == envelopeMagic
+}
+
+// EncryptWriter returns a writer that seals what is written to it with the
+// active key and writes it to w, for data too large to be held in memory as
+// Encrypt needs it to be. The data is sealed in chunks along with their
+// position in the stream, and the last chunk is marked as such, so chunks
+// cannot be reordered, dropped or cut off without DecryptReader failing.
+// Close seals the last chunk; it does not close w.
+func (r *KeyRing) EncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, ok := r.keys[r.active]; !ok {
+		return nil, ErrUnknownKey
+	}
+
+	header := make([]byte, envelopeHeaderSize)
+	copy(header, streamMagic)
+	binary.BigEndian.PutUint32(header[4:], r.active)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{keys: r, id: r.active, w: w}, nil
+}
+
+// DecryptReader returns a reader of the data written by EncryptWriter to the
+// stream read by rd. Reads fail with ErrCorruptRecord once a chunk does not
+// open or the stream ends before its last chunk.
+func (r *KeyRing) DecryptReader(rd io.Reader) io.Reader {
+	return &decryptReader{keys: r, r: rd}
+}
+
+// IsEncryptedStream reports whether data starts a stream written by
+// EncryptWriter.
+func IsEncryptedStream(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == streamMagic
+}
+
+// Each chunk of a stream is laid out as:
+//
+//	flags (1) | sealed length (4) | sealed chunk
+//
+// and is sealed with its position in the stream and its flags as additional
+// data.
+func chunkBinding(seq uint64, flags byte) []byte {
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint64(b, seq)
+	b[8] = flags
+
+	return b
+}
+
+type encryptWriter struct {
+	keys *KeyRing
+	id   uint32
+	w    io.Writer
+	buf  []byte
+	seq  uint64
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		room := streamChunkSize - len(e.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		e.buf = append(e.buf, p[:room]...)
+		p = p[room:]
+
+		if len(e.buf) == streamChunkSize {
+			if err := e.flush(0); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(streamFinalFlag)
+}
+
+func (e *encryptWriter) flush(flags byte) error {
+	sealed, err := e.keys.seal(e.id, e.buf, chunkBinding(e.seq, flags))
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 5)
+	header[0] = flags
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.seq++
+	e.buf = e.buf[:0]
+
+	return nil
+}
+
+type decryptReader struct {
+	keys    *KeyRing
+	r       io.Reader
+	id      uint32
+	started bool
+	seq     uint64
+	buf     []byte
+	done    bool
+	err     error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.next()
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+
+	return n, nil
+}
+
+// next opens the next chunk into buf, returning io.EOF after the last one.
+func (d *decryptReader) next() error {
+	if !d.started {
+		header := make([]byte, envelopeHeaderSize)
+		if _, err := io.ReadFull(d.r, header); err != nil || !IsEncryptedStream(header) {
+			return ErrCorruptRecord
+		}
+		d.id = binary.BigEndian.Uint32(header[4:])
+		d.started = true
+	}
+	// Anything after the last chunk is not part of the stream.
+	if d.done {
+		return io.EOF
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return ErrCorruptRecord
+	}
+
+	// Sealing adds a nonce and a tag, well under a kilobyte.
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > streamChunkSize+1024 {
+		return ErrCorruptRecord
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrCorruptRecord
+	}
+
+	plain, err := d.keys.open(d.id, sealed, chunkBinding(d.seq, header[0]))
+	if err != nil {
+		return err
+	}
+	d.seq++
+	d.buf = plain
+	d.done = header[0]&streamFinalFlag != 0
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testKey2 = "2:1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func TestParseKeyRing(t *testing.T) {
+	ring, err := ParseKeyRing("# keys\n" + testKey1 + "," + testKey2 + "\n")
+
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "active", uint32(2), ring.ActiveKey())
+
+	for _, text := range []string{"", "1", "x:00", "1:zz", "1:0001", "0:000102030405060708090a0b0c0d0e0f"} {
+		if _, err := ParseKeyRing(text); err == nil {
+			t.Errorf("Expected an error for `%s`\n", text)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ring, _ := ParseKeyRing(testKey1)
+	data := []byte("some secret payload")
+
+	encrypted, err := ring.Encrypt(data)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "encrypted", true, IsEncrypted(encrypted))
+	assetEqual(t, "leak", false, bytes.Contains(encrypted, data))
+
+	decrypted, err := ring.Decrypt(encrypted)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "decrypted", string(data), string(decrypted))
+
+	plain, err := ring.Decrypt(data)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "plain", string(data), string(plain))
+
+	other, _ := ParseKeyRing(testKey2)
+	_, err = other.Decrypt(encrypted)
+	assetEqual(t, "unknown key", ErrUnknownKey, err)
+}
+
+func TestEncryptStream(t *testing.T) {
+	ring, _ := ParseKeyRing(testKey1)
+	data := []byte(strings.Repeat("some secret payload ", 10000))
+
+	buf := bytes.NewBuffer([]byte{})
+	w, err := ring.EncryptWriter(buf)
+	assetEqual(t, "err", nil, err)
+	w.Write(data[:100])
+	w.Write(data[100:])
+	assetEqual(t, "close", nil, w.Close())
+
+	stream := buf.Bytes()
+	assetEqual(t, "encrypted", true, IsEncryptedStream(stream))
+	assetEqual(t, "leak", false, bytes.Contains(stream, data[:20]))
+
+	decrypted, err := ioutil.ReadAll(ring.DecryptReader(bytes.NewReader(stream)))
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "decrypted", string(data), string(decrypted))
+
+	// Cut off after the first chunk, which is not the last one.
+	first := envelopeHeaderSize + 5 + int(binary.BigEndian.Uint32(stream[envelopeHeaderSize+1:]))
+	_, err = ioutil.ReadAll(ring.DecryptReader(bytes.NewReader(stream[:first])))
+	assetEqual(t, "truncated", ErrCorruptRecord, err)
+
+	// Marked as the last chunk.
+	forged := append([]byte{}, stream[:first]...)
+	forged[envelopeHeaderSize] = streamFinalFlag
+	_, err = ioutil.ReadAll(ring.DecryptReader(bytes.NewReader(forged)))
+	assetEqual(t, "forged", ErrCorruptRecord, err)
+
+	other, _ := ParseKeyRing(testKey2)
+	_, err = ioutil.ReadAll(other.DecryptReader(bytes.NewReader(stream)))
+	assetEqual(t, "unknown key", ErrUnknownKey, err)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	ring, _ := ParseKeyRing(testKey1)
+	N := 100
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCompression(SnappyCompression))
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("secret_key_%d", i), strings.Repeat("secret_value", 10))
+	}
+	store.Close()
+
+	for _, path := range []string{dbPath, indexPath} {
+		data, _ := ioutil.ReadFile(path)
+		assetEqual(t, path, false, bytes.Contains(data, []byte("secret")))
+	}
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	defer store.Close()
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("secret_key_%d", i))
+		assetEqual(t, fmt.Sprintf("secret_key_%d", i), strings.Repeat("secret_value", 10), value)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 50
+	check := func(store *KV) {
+		t.Helper()
+
+		for i := 0; i < N; i++ {
+			value, _ := store.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+		}
+	}
+
+	// Start with a plaintext store to check it migrates to encryption.
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	for i := 0; i < N/2; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.Close()
+
+	ring, _ := ParseKeyRing(testKey1)
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	for i := N / 2; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+
+	assetEqual(t, "key id", uint32(0), store.format.keyID)
+	check(store)
+
+	store.CompactData()
+	assetEqual(t, "key id", uint32(1), store.format.keyID)
+	check(store)
+	store.Close()
+
+	ring, _ = ParseKeyRing(testKey1 + "," + testKey2)
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	check(store)
+
+	store.CompactData()
+	assetEqual(t, "key id", uint32(2), store.format.keyID)
+	assetEqual(t, "index key id", uint32(2), store.indexFormat.keyID)
+	store.Close()
+
+	ring, _ = ParseKeyRing(testKey2)
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	defer store.Close()
+
+	check(store)
+}
+
+func TestEncryptedValueSwap(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	ring, _ := ParseKeyRing(testKey1)
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring))
+	store.Set("key_a", "value_a")
+	store.Set("key_b", "value_b")
+	store.Close()
+
+	data, _ := ioutil.ReadFile(dbPath)
+	original := append([]byte{}, data...)
+
+	// Swap the sealed values of the two records and fix up their checksums,
+	// as someone with write access to the file but not the key could.
+	var records [][]byte
+	for offset := fileHeaderSize; offset < len(data); {
+		keyLength := int(binary.BigEndian.Uint64(data[offset+5 : offset+13]))
+		valLength := int(binary.BigEndian.Uint64(data[offset+13 : offset+21]))
+		size := recordHeaderSize + keyLength + valLength
+
+		records = append(records, data[offset:offset+size])
+		offset += size
+	}
+	a, b := records[0], records[1]
+
+	swap := func(r, val []byte) {
+		keyLength := int(binary.BigEndian.Uint64(r[5:13]))
+		copy(r[recordHeaderSize+keyLength:], val)
+		binary.BigEndian.PutUint32(r[:4], crc32.ChecksumIEEE(r[4:]))
+	}
+	valueOf := func(r []byte) []byte {
+		keyLength := int(binary.BigEndian.Uint64(r[5:13]))
+		return append([]byte{}, r[recordHeaderSize+keyLength:]...)
+	}
+	va, vb := valueOf(a), valueOf(b)
+	swap(a, vb)
+	swap(b, va)
+	ioutil.WriteFile(dbPath, data, 0644)
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	_, ok := store.Get("key_a")
+	assetEqual(t, "swapped value", false, ok)
+	store.Close()
+
+	// Pointing the index entry of one key at the record of another does not
+	// return the other value either.
+	ioutil.WriteFile(dbPath, original, 0644)
+
+	index, _ := ioutil.ReadFile(indexPath)
+	first := fileHeaderSize
+	second := first + 16 + int(binary.BigEndian.Uint64(index[first:first+8]))
+	offsetA := append([]byte{}, index[first+8:first+16]...)
+	copy(index[first+8:first+16], index[second+8:second+16])
+	copy(index[second+8:second+16], offsetA)
+	ioutil.WriteFile(indexPath, index, 0644)
+
+	store = NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	defer store.Close()
+
+	_, ok = store.Get("key_a")
+	assetEqual(t, "swapped offset", false, ok)
+}
+
+func TestKeyBoundVersion(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	ring, _ := ParseKeyRing(testKey1)
+
+	// Version 2 files seal values without their key and stay readable.
+	f := fileFormat{version: 2, keyID: 1}
+	data := bytes.NewBuffer(fileHeader(dataFileMagic, f.keyID))
+	data.Bytes()[4] = f.version
+	appendRecord(data, f, ring, "key", "value", NoCompression)
+	ioutil.WriteFile(dbPath, data.Bytes(), 0644)
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring), WithCacheSize(0))
+	defer store.Close()
+
+	value, _ := store.Get("key")
+	assetEqual(t, "version 2", "value", value)
+
+	store.CompactData()
+	assetEqual(t, "version", keyBoundVersion, store.format.version)
+
+	value, _ = store.Get("key")
+	assetEqual(t, "version 3", "value", value)
+}
+
+func TestUnknownKey(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	ring, _ := ParseKeyRing(testKey1)
+	store := NewKV(dbPath, indexPath, 1000, 10, WithKeyRing(ring))
+	store.Set("key", "value")
+	store.Close()
+
+	other, _ := ParseKeyRing(testKey2)
+	for _, opts := range [][]Option{nil, {WithKeyRing(other)}, {WithKeyRing(other), WithReadOnly()}} {
+		_, err := OpenKV(dbPath, indexPath, opts...)
+		assetEqual(t, "open", true, errors.Is(err, ErrUnknownKey))
+	}
+
+	_, err := Check(dbPath, indexPath, WithKeyRing(other))
+	assetEqual(t, "check", true, errors.Is(err, ErrUnknownKey))
+
+	// The store is not left locked.
+	store, err = OpenKV(dbPath, indexPath, WithKeyRing(ring))
+	assetEqual(t, "open", nil, err)
+	store.Close()
+}
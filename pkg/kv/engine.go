@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+)
+
+// Engine is a key-value store as the server uses it. KV implements it, with
+// its data on disk or, when opened with OpenMemoryKV, in memory only.
+type Engine interface {
+	Get(key string) (string, bool)
+	Set(key, value string) error
+	Delete(key string) error
+	// Batch applies ops in order. Readers see either none or all of them.
+	Batch(ops []BatchOp) error
+	// Iterate calls fn with every live key and its value, in key order, as
+	// of when it was called, until fn returns false.
+	Iterate(fn func(key, value string) bool) error
+	// Snapshot returns a point-in-time copy of every live key and its value.
+	Snapshot() (map[string]string, error)
+	// Reset discards everything in the store and replaces it with items.
+	Reset(items map[string]string) error
+	// Close closes the store, returning an error if writes were lost.
+	Close() error
+}
+
+// BatchOp is a write in a batch: a Set of Key to Value, or a Delete of Key
+// if Delete is true.
+type BatchOp struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+var _ Engine = (*KV)(nil)
+
+// Batch applies ops to the memtable in one go, so that readers see either
+// none or all of them, and that they are flushed together.
+func (kv *KV) Batch(ops []BatchOp) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	// Like set, a full memtable is rotated before the batch goes in, so that
+	// a failed flush fails the batch instead of it being applied anyway. The
+	// batch may overfill the memtable; the next write rotates it.
+	var mt *memTable
+	for {
+		kv.lock.Lock()
+		mt = kv.active
+
+		if mt.bytes() < kv.memTableSize || kv.isCompacting.Value() || kv.inMemory {
+			break
+		}
+		kv.lock.Unlock()
+
+		if err := kv.rotate(mt); err != nil {
+			return err
+		}
+	}
+
+	for _, op := range ops {
+		key, value := op.Key, op.Value
+		if op.Delete {
+			if kv.inMemory {
+				atomic.AddInt64(&kv.userBytes, int64(len(key)))
+				kv.change(key, "__KVGO_TOMBSTONE__", func() { mt.delete(key) })
+				continue
+			}
+			value = "__KVGO_TOMBSTONE__"
+		}
+
+		atomic.AddInt64(&kv.userBytes, int64(len(key)+len(value)))
+		kv.change(key, value, func() { mt.set(key, value) })
+	}
+	kv.lock.Unlock()
+
+	return nil
+}
+
+// Iterate calls fn with every live key and its value, in key order, until fn
+// returns false. It works on a copy taken with Items, so the store can be
+// written to meanwhile.
+func (kv *KV) Iterate(fn func(key, value string) bool) error {
+	return kv.IterateContext(context.Background(), fn)
+}
+
+// IterateContext is Iterate, giving up with the error of ctx once it is done.
+func (kv *KV) IterateContext(ctx context.Context, fn func(key, value string) bool) error {
+	items, err := kv.items(ctx)
+	if err != nil {
+		return err
+	}
+
+	iterateItems(items, func(key, value string) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return fn(key, value)
+	})
+
+	return err
+}
+
+// Snapshot returns a point-in-time copy of every live key and its value. It
+// fails with the error of the first record that cannot be read.
+func (kv *KV) Snapshot() (map[string]string, error) {
+	return kv.items(context.Background())
+}
+
+func iterateItems(items map[string]string, fn func(key, value string) bool) error {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !fn(k, items[k]) {
+			break
+		}
+	}
+
+	return nil
+}
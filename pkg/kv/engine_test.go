@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEngine(t *testing.T, name string, e Engine) {
+	e.Set("a", "1")
+	e.Set("b", "2")
+	e.Set("c", "3")
+	e.Delete("b")
+
+	value, ok := e.Get("a")
+	assetEqual(t, name+" a", "1", value)
+	_, ok = e.Get("b")
+	assetEqual(t, name+" deleted b", false, ok)
+
+	e.Batch([]BatchOp{{Key: "d", Value: "4"}, {Key: "a", Delete: true}, {Key: "c", Value: "33"}})
+
+	var keys []string
+	e.Iterate(func(key, value string) bool {
+		keys = append(keys, key+"="+value)
+		return true
+	})
+	assetEqual(t, name+" iterate", "c=33,d=4", strings.Join(keys, ","))
+
+	keys = nil
+	e.Iterate(func(key, value string) bool {
+		keys = append(keys, key)
+		return false
+	})
+	assetEqual(t, name+" stopped iterate", "c", strings.Join(keys, ","))
+
+	snapshot, err := e.Snapshot()
+	assetEqual(t, name+" err", nil, err)
+	assetEqual(t, name+" snapshot", 2, len(snapshot))
+
+	e.Set("e", "5")
+	assetEqual(t, name+" snapshot after set", 2, len(snapshot))
+
+	e.Reset(map[string]string{"x": "1"})
+	_, ok = e.Get("c")
+	assetEqual(t, name+" c after reset", false, ok)
+	value, _ = e.Get("x")
+	assetEqual(t, name+" x after reset", "1", value)
+}
+
+func TestEngines(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	testEngine(t, "kv", store)
+	memory := NewMemoryKV()
+	defer memory.Close()
+
+	testEngine(t, "memory", memory)
+}
+
+func TestBatchFlush(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithMemTableSize(1<<10))
+
+	N := 1000
+	ops := make([]BatchOp, 0, N)
+	for i := 0; i < N; i++ {
+		ops = append(ops, BatchOp{Key: fmt.Sprintf("key_%d", i), Value: fmt.Sprintf("value_%d", i)})
+	}
+	store.Batch(ops)
+	store.Close()
+
+	store = NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+}
+
+func TestBatchFlushError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithMemTableSize(256), WithMaxImmutableMemTables(1))
+
+	// A directory in place of the data file makes every flush fail.
+	os.Rename(dbPath, dbPath+".moved")
+	os.Mkdir(dbPath, 0755)
+
+	var err error
+	written := 0
+	for i := 0; i < 1000 && err == nil; i++ {
+		ops := []BatchOp{{Key: fmt.Sprintf("key_%d", i), Value: fmt.Sprintf("value_%d", i)}}
+		if err = store.Batch(ops); err == nil {
+			written++
+		}
+	}
+	if err == nil {
+		t.Errorf("Expected batches to fail once the flush queue is full\n")
+	}
+
+	// The failed batch was not applied.
+	_, ok := store.Get(fmt.Sprintf("key_%d", written))
+	assetEqual(t, "failed batch", false, ok)
+	value, _ := store.Get(fmt.Sprintf("key_%d", written-1))
+	assetEqual(t, "last batch", fmt.Sprintf("value_%d", written-1), value)
+
+	os.Remove(dbPath)
+	os.Rename(dbPath+".moved", dbPath)
+	assetEqual(t, "close", nil, store.Close())
+}
+
+func TestContextCancelled(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	setRange(store, 0, 100)
+	store.SyncToDisk()
+	for i := 0; i < 100; i += 2 {
+		store.Set(fmt.Sprintf("large_%d", i), largeValue(i, 2048))
+	}
+	store.SyncToDisk()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := 0
+	err := store.IterateContext(ctx, func(key, value string) bool {
+		if n++; n == 10 {
+			cancel()
+		}
+		return true
+	})
+	assetEqual(t, "iterate err", context.Canceled, err)
+	assetEqual(t, "iterated", 10, n)
+
+	_, err = store.ExportContext(ctx, bytes.NewBuffer([]byte{}), BinaryDump)
+	assetEqual(t, "export err", context.Canceled, err)
+	// Wrapped so that the export goes through Iterate like for other engines.
+	_, err = ExportEngineContext(ctx, struct{ Engine }{store}, bytes.NewBuffer([]byte{}), BinaryDump)
+	assetEqual(t, "export engine err", context.Canceled, err)
+	_, err = store.ImportContext(ctx, bytes.NewBuffer([]byte{}), BinaryDump)
+	assetEqual(t, "import err", context.Canceled, err)
+	_, err = store.BackupContext(ctx, bytes.NewBuffer([]byte{}))
+	assetEqual(t, "backup err", context.Canceled, err)
+	assetEqual(t, "compact data err", context.Canceled, store.CompactDataContext(ctx))
+	assetEqual(t, "compact blobs err", context.Canceled, store.CompactBlobsContext(ctx, 0))
+
+	checkRange(t, store, 0, 100)
+
+	assetEqual(t, "compact data", nil, store.CompactData())
+	checkRange(t, store, 0, 100)
+}
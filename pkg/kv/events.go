@@ -0,0 +1,231 @@
+package kv
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventListener is notified of what happens in the background of a store,
+// for metrics and tracing. Callbacks are invoked one at a time, in the order
+// the events happened, from a goroutine of their own, so a slow listener
+// never holds up reads and writes; it only delays the delivery of later
+// events. Embed BaseEventListener to implement only some of the callbacks.
+type EventListener interface {
+	OnFlushBegin(FlushInfo)
+	OnFlushEnd(FlushInfo)
+	OnCompactionBegin(CompactionInfo)
+	OnCompactionEnd(CompactionInfo)
+	OnWALSync(SyncInfo)
+	OnCorruption(CorruptionInfo)
+	OnStallBegin(StallInfo)
+	OnStallEnd(StallInfo)
+	OnBackgroundError(BackgroundErrorInfo)
+}
+
+// BaseEventListener implements every callback of EventListener as a no-op.
+type BaseEventListener struct{}
+
+func (BaseEventListener) OnFlushBegin(FlushInfo)                {}
+func (BaseEventListener) OnFlushEnd(FlushInfo)                  {}
+func (BaseEventListener) OnCompactionBegin(CompactionInfo)      {}
+func (BaseEventListener) OnCompactionEnd(CompactionInfo)        {}
+func (BaseEventListener) OnWALSync(SyncInfo)                    {}
+func (BaseEventListener) OnCorruption(CorruptionInfo)           {}
+func (BaseEventListener) OnStallBegin(StallInfo)                {}
+func (BaseEventListener) OnStallEnd(StallInfo)                  {}
+func (BaseEventListener) OnBackgroundError(BackgroundErrorInfo) {}
+
+// FlushInfo describes the flush of a memtable to disk. BytesWritten,
+// Duration and Err are only set when the flush ends. Err is set if the flush
+// failed; the memtable is then flushed again later.
+type FlushInfo struct {
+	Entries       int64
+	MemTableBytes int64
+	BytesWritten  int64
+	StartTime     time.Time
+	Duration      time.Duration
+	Err           error
+}
+
+// CompactionInfo describes a compaction of the data files (CompactData) or,
+// when Blobs is set, of the blob files (CompactBlobs). The sizes, Keys,
+// Duration and Err are only set when the compaction ends; Err is the last
+// error it ran into.
+type CompactionInfo struct {
+	Blobs            bool
+	InputBytes       int64
+	OutputBytes      int64
+	Keys             int64
+	BlobFilesRemoved int
+	StartTime        time.Time
+	Duration         time.Duration
+	Err              error
+}
+
+// SyncInfo describes an fsync of the data file. kvgo has no separate
+// write-ahead log: writes become durable when a flush syncs them to the data
+// file.
+type SyncInfo struct {
+	Path     string
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// CorruptionInfo describes a record or file that failed its checks. Offset
+// is -1 when the corruption is not tied to a single record.
+type CorruptionInfo struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+// StallInfo describes writers being blocked until the flusher catches up
+// with the memtables waiting to be flushed. Duration is only set when the
+// stall ends.
+type StallInfo struct {
+	ImmutableMemTables    int
+	MaxImmutableMemTables int
+	Duration              time.Duration
+}
+
+// BackgroundErrorInfo describes an error met by a flush ("flush"), a
+// compaction ("compaction" or "blob compaction") or the snapshot of an
+// in-memory store ("snapshot"), which has no caller to return it to.
+type BackgroundErrorInfo struct {
+	Operation string
+	Err       error
+}
+
+// eventQueue delivers events to a listener from a goroutine of its own. It
+// is unbounded so that queueing an event never blocks; events are rare
+// compared to reads and writes. A nil queue drops every event.
+type eventQueue struct {
+	listener EventListener
+	lock     sync.Mutex
+	cond     *sync.Cond
+	events   []func(EventListener)
+	closed   bool
+	done     chan struct{}
+}
+
+func newEventQueue(listener EventListener) *eventQueue {
+	if listener == nil {
+		return nil
+	}
+
+	q := &eventQueue{listener: listener, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.lock)
+
+	return q
+}
+
+// start starts delivering events, including the ones queued before it was
+// called.
+func (q *eventQueue) start() {
+	if q != nil {
+		go q.run()
+	}
+}
+
+func (q *eventQueue) push(event func(EventListener)) {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	if !q.closed {
+		q.events = append(q.events, event)
+		q.cond.Signal()
+	}
+	q.lock.Unlock()
+}
+
+func (q *eventQueue) run() {
+	defer close(q.done)
+
+	for {
+		q.lock.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		events := q.events
+		q.events = nil
+		closed := q.closed
+		q.lock.Unlock()
+
+		for _, event := range events {
+			event(q.listener)
+		}
+		if closed && len(events) == 0 {
+			return
+		}
+	}
+}
+
+// close delivers the events queued so far and stops the queue. It must only
+// be called once start was.
+func (q *eventQueue) close() {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	q.closed = true
+	q.cond.Signal()
+	q.lock.Unlock()
+
+	<-q.done
+}
+
+// backgroundError logs err, met by the background operation op, and reports
+// it to the listener.
+func (kv *KV) backgroundError(op string, err error) {
+	log.Error("Error: ", err)
+
+	kv.events.push(func(l EventListener) {
+		l.OnBackgroundError(BackgroundErrorInfo{Operation: op, Err: err})
+	})
+}
+
+// corruption reports err to the listener if it means that the record at
+// offset in the file at path is corrupt.
+func (kv *KV) corruption(path string, offset int64, err error) {
+	if !errors.Is(err, ErrCorruptRecord) {
+		return
+	}
+
+	kv.events.push(func(l EventListener) {
+		l.OnCorruption(CorruptionInfo{Path: path, Offset: offset, Err: err})
+	})
+}
+
+// The notify methods queue an event for the listener. They take the info by
+// value so that the caller can go on filling in its copy.
+
+func (kv *KV) notifyFlushBegin(info FlushInfo) {
+	kv.events.push(func(l EventListener) { l.OnFlushBegin(info) })
+}
+
+func (kv *KV) notifyFlushEnd(info FlushInfo) {
+	kv.events.push(func(l EventListener) { l.OnFlushEnd(info) })
+}
+
+func (kv *KV) notifyCompactionBegin(info CompactionInfo) {
+	kv.events.push(func(l EventListener) { l.OnCompactionBegin(info) })
+}
+
+func (kv *KV) notifyCompactionEnd(info CompactionInfo) {
+	kv.events.push(func(l EventListener) { l.OnCompactionEnd(info) })
+}
+
+func (kv *KV) notifyStallBegin(info StallInfo) {
+	kv.events.push(func(l EventListener) { l.OnStallBegin(info) })
+}
+
+func (kv *KV) notifyStallEnd(info StallInfo) {
+	kv.events.push(func(l EventListener) { l.OnStallEnd(info) })
+}
@@ -0,0 +1,137 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingListener struct {
+	BaseEventListener
+
+	lock        sync.Mutex
+	events      []string
+	flushes     []FlushInfo
+	compactions []CompactionInfo
+	syncs       []SyncInfo
+	corruptions []CorruptionInfo
+}
+
+func (l *recordingListener) record(event string) {
+	l.events = append(l.events, event)
+}
+
+func (l *recordingListener) OnFlushBegin(info FlushInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("flush begin")
+}
+
+func (l *recordingListener) OnFlushEnd(info FlushInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("flush end")
+	l.flushes = append(l.flushes, info)
+}
+
+func (l *recordingListener) OnCompactionBegin(info CompactionInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("compaction begin")
+}
+
+func (l *recordingListener) OnCompactionEnd(info CompactionInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("compaction end")
+	l.compactions = append(l.compactions, info)
+}
+
+func (l *recordingListener) OnWALSync(info SyncInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("sync")
+	l.syncs = append(l.syncs, info)
+}
+
+func (l *recordingListener) OnCorruption(info CorruptionInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record("corruption")
+	l.corruptions = append(l.corruptions, info)
+}
+
+func TestEventListener(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	listener := &recordingListener{}
+
+	store := NewKV(dbPath, filepath.Join(tmpDir, "indexes.idx"), 1000, 10, WithEventListener(listener))
+
+	N := 100
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+
+	for i := 0; i < N/2; i++ {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.SyncToDisk()
+	store.CompactData()
+	store.Close()
+
+	assetEqual(
+		t,
+		"events",
+		"flush begin,sync,flush end,flush begin,sync,flush end,compaction begin,compaction end",
+		strings.Join(listener.events, ","),
+	)
+
+	assetEqual(t, "flushed entries", int64(N), listener.flushes[0].Entries)
+	if listener.flushes[0].BytesWritten == 0 || listener.flushes[0].Duration == 0 {
+		t.Errorf("Expected the bytes written and duration of the flush, got %+v\n", listener.flushes[0])
+	}
+
+	assetEqual(t, "sync path", dbPath, listener.syncs[0].Path)
+	assetEqual(t, "sync err", nil, listener.syncs[0].Err)
+
+	compaction := listener.compactions[0]
+	assetEqual(t, "compacted keys", int64(N/2), compaction.Keys)
+	assetEqual(t, "compaction err", nil, compaction.Err)
+	if compaction.OutputBytes >= compaction.InputBytes {
+		t.Errorf("Expected the compaction to shrink the data file, got %d -> %d\n", compaction.InputBytes, compaction.OutputBytes)
+	}
+}
+
+func TestEventListenerCorruption(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	store.Set("key", "value")
+	store.Close()
+
+	data, _ := ioutil.ReadFile(dbPath)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(dbPath, data, 0644)
+
+	listener := &recordingListener{}
+	store = NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0), WithEventListener(listener))
+	store.Get("key")
+	store.Close()
+
+	assetEqual(t, "corruptions", 1, len(listener.corruptions))
+	assetEqual(t, "corruption path", dbPath, listener.corruptions[0].Path)
+	assetEqual(t, "corruption offset", int64(fileHeaderSize), listener.corruptions[0].Offset)
+	assetEqual(t, "corruption err", ErrCorruptRecord, listener.corruptions[0].Err)
+}
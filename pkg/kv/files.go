@@ -0,0 +1,176 @@
+package kv
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultMaxOpenFiles = 64
+
+// readHandle is a shared read-only handle on a data or blob file. Reads go through
+// ReadAt so any number of goroutines can use the same handle at once. When
+// the file is memory mapped, reads within the mapped region are served from
+// memory and only bytes appended after the mapping was made hit the file.
+type readHandle struct {
+	path    string
+	file    *os.File
+	data    []byte
+	format  fileFormat
+	keys    *KeyRing
+	refs    int
+	retired bool
+	elem    *list.Element
+}
+
+func (h *readHandle) ReadAt(b []byte, off int64) (int, error) {
+	if off >= 0 && off+int64(len(b)) <= int64(len(h.data)) {
+		return copy(b, h.data[off:]), nil
+	}
+
+	return h.file.ReadAt(b, off)
+}
+
+func (h *readHandle) close() {
+	if h.data != nil {
+		if err := munmap(h.data); err != nil {
+			log.Error("Error: ", err)
+		}
+		h.data = nil
+	}
+	h.file.Close()
+}
+
+// fdCache keeps data files open between reads. Handles are reference counted:
+// a handle that is evicted or retired while readers still hold it is closed
+// (and unmapped) by the last release, so a retired file is never closed under
+// a reader.
+type fdCache struct {
+	lock    sync.Mutex
+	limit   int
+	mmap    bool
+	keys    *KeyRing
+	handles map[string]*readHandle
+	lru     *list.List
+}
+
+func newFDCache(limit int, mmap bool, keys *KeyRing) *fdCache {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &fdCache{
+		limit:   limit,
+		mmap:    mmap,
+		keys:    keys,
+		handles: make(map[string]*readHandle),
+		lru:     list.New(),
+	}
+}
+
+func (c *fdCache) acquire(path string) (*readHandle, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if h, ok := c.handles[path]; ok {
+		h.refs++
+		c.lru.MoveToFront(h.elem)
+		return h, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &readHandle{path: path, file: f, refs: 1}
+
+	if c.mmap {
+		h.data = mapFile(f)
+	}
+	h.format = readFormat(h, fileMagic(path))
+	h.keys = c.keys
+	h.elem = c.lru.PushFront(h)
+	c.handles[path] = h
+
+	c.evict()
+
+	return h, nil
+}
+
+func (c *fdCache) release(h *readHandle) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	h.refs--
+	if h.refs == 0 && h.retired {
+		h.close()
+	}
+}
+
+// retire drops the cached handle for path, closing it as soon as no reader
+// holds it. It must be called whenever the file behind path is replaced or
+// removed.
+func (c *fdCache) retire(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if h, ok := c.handles[path]; ok {
+		c.retireHandle(h)
+	}
+}
+
+func (c *fdCache) retireHandle(h *readHandle) {
+	delete(c.handles, h.path)
+	c.lru.Remove(h.elem)
+	h.retired = true
+
+	if h.refs == 0 {
+		h.close()
+	}
+}
+
+// mapFile maps the current contents of f. On failure, or on platforms
+// without mmap support, nil is returned and reads fall back to ReadAt.
+func mapFile(f *os.File) []byte {
+	st, err := f.Stat()
+	if err != nil || st.Size() == 0 {
+		return nil
+	}
+
+	data, err := mmap(f, st.Size())
+	if err != nil {
+		log.Warn("Falling back to ReadAt for ", f.Name(), ": ", err)
+		return nil
+	}
+
+	return data
+}
+
+func (c *fdCache) evict() {
+	for e := c.lru.Back(); e != nil && len(c.handles) > c.limit; {
+		prev := e.Prev()
+		if h := e.Value.(*readHandle); h.refs == 0 {
+			c.retireHandle(h)
+		}
+		e = prev
+	}
+}
+
+func (c *fdCache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, h := range c.handles {
+		c.retireHandle(h)
+	}
+}
+
+func (c *fdCache) openFiles() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.handles)
+}
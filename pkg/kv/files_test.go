@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFDCacheLimit(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	files := newFDCache(2, false, nil)
+
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("data_%d.db", i))
+		ioutil.WriteFile(path, []byte(fmt.Sprintf("value_%d", i)), 0644)
+
+		h, err := files.acquire(path)
+		if err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+		files.release(h)
+	}
+
+	assetEqual(t, "open files", 2, files.openFiles())
+
+	files.close()
+	assetEqual(t, "open files", 0, files.openFiles())
+}
+
+func TestFDCacheRetire(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "data.db")
+	ioutil.WriteFile(path, []byte("old_value"), 0644)
+
+	files := newFDCache(DefaultMaxOpenFiles, false, nil)
+
+	h, _ := files.acquire(path)
+
+	ioutil.WriteFile(path+".new", []byte("new_value"), 0644)
+	os.Rename(path+".new", path)
+	files.retire(path)
+
+	data := make([]byte, 9)
+	_, err := h.ReadAt(data, 0)
+	assetEqual(t, "read retired", nil, err)
+	assetEqual(t, "read retired", "old_value", string(data))
+
+	files.release(h)
+
+	_, err = h.ReadAt(data, 0)
+	if err == nil {
+		t.Errorf("Expected retired handle to be closed after release\n")
+	}
+
+	h, _ = files.acquire(path)
+	h.ReadAt(data, 0)
+	assetEqual(t, "read new", "new_value", string(data))
+	files.release(h)
+}
+
+func TestConcurrentReads(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithCacheSize(0))
+	defer store.Close()
+	N := 1000
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				value, _ := store.Get(fmt.Sprintf("key_%d", i))
+				assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assetEqual(t, "open files", 1, store.files.openFiles())
+}
+
+func TestMmapRetire(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "data.db")
+	ioutil.WriteFile(path, []byte("old_value"), 0644)
+
+	files := newFDCache(DefaultMaxOpenFiles, true, nil)
+
+	h, _ := files.acquire(path)
+	assetEqual(t, "mapped", 9, len(h.data))
+
+	os.Remove(path)
+	files.retire(path)
+
+	data := make([]byte, 9)
+	_, err := h.ReadAt(data, 0)
+	assetEqual(t, "read retired", nil, err)
+	assetEqual(t, "read retired", "old_value", string(data))
+
+	files.release(h)
+	assetEqual(t, "unmapped", 0, len(h.data))
+}
+
+func TestMmapReads(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 100, 10, WithMmap(true), WithCacheSize(0))
+	defer store.Close()
+	N := 1000
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	for i := 0; i < N; i += 3 {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.SyncToDisk()
+
+	check := func() {
+		for i := 0; i < N; i++ {
+			value, ok := store.Get(fmt.Sprintf("key_%d", i))
+			if i%3 == 0 {
+				assetEqual(t, fmt.Sprintf("key_%d", i), false, ok)
+			} else {
+				assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+			}
+		}
+	}
+
+	check()
+	store.CompactData()
+	check()
+}
@@ -0,0 +1,407 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Data and index files start with a header holding a magic string, the
+// format version and the id of the key their records are encrypted with (0
+// when they are not encrypted):
+//
+//	magic (4) | version (1) | key id (4) | reserved (7)
+//
+// Version 2 and 3 data records are laid out as:
+//
+//	crc32 (4) | flags (1) | key length (8) | value length (8) | key | value
+//
+// The checksum covers everything after it and the low bits of flags hold the
+// Compression of the value. When blobPointerFlag is set the value was stored
+// in a blob file and the record holds a blobPointer to it instead. In
+// encrypted files the key and the value are each sealed with AES-GCM after
+// compression, and the lengths are those of the sealed bytes. From version 3
+// on the value is sealed with its key as additional data, so a value cannot
+// be moved to another record without failing to open.
+//
+// Index records are laid out as:
+//
+//	key length (8) | offset (8) | key
+//
+// Files written before the header was introduced are version 1. They are
+// never encrypted and their data records are bare:
+//
+//	key length (8) | value length (8) | key | value
+const (
+	dataFileMagic    = "KVGD"
+	indexFileMagic   = "KVGI"
+	fileHeaderSize   = 16
+	recordHeaderSize = 21
+
+	legacyVersion   uint8 = 1
+	keyBoundVersion uint8 = 3
+	currentVersion  uint8 = keyBoundVersion
+
+	compressionMask = 0x0f
+	blobPointerFlag = 0x10
+)
+
+var ErrCorruptRecord = errors.New("kv: corrupt record")
+
+// fileFormat describes how the records of a data or index file are encoded.
+type fileFormat struct {
+	version uint8
+	keyID   uint32
+}
+
+var legacyFormat = fileFormat{version: legacyVersion}
+
+func fileHeader(magic string, keyID uint32) []byte {
+	header := make([]byte, fileHeaderSize)
+	copy(header, magic)
+	header[4] = currentVersion
+	binary.BigEndian.PutUint32(header[5:9], keyID)
+
+	return header
+}
+
+// readFormat returns the format of the file read by r, which is expected to
+// start with the given magic string.
+func readFormat(r io.ReaderAt, magic string) fileFormat {
+	header := make([]byte, fileHeaderSize)
+
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return legacyFormat
+	}
+	if string(header[:4]) != magic {
+		return legacyFormat
+	}
+
+	return fileFormat{version: header[4], keyID: binary.BigEndian.Uint32(header[5:9])}
+}
+
+// fileMagic returns the magic string the file at path is expected to start
+// with.
+func fileMagic(path string) string {
+	if strings.HasSuffix(path, blobFileExt) {
+		return blobFileMagic
+	}
+
+	return dataFileMagic
+}
+
+// headerSize returns the offset of the first record in a file of format f.
+func (f fileFormat) headerSize() int64 {
+	if f.version == legacyVersion {
+		return 0
+	}
+
+	return fileHeaderSize
+}
+
+func (f fileFormat) seal(keys *KeyRing, data, aad []byte) ([]byte, error) {
+	if f.keyID == 0 {
+		return data, nil
+	}
+
+	return keys.seal(f.keyID, data, aad)
+}
+
+func (f fileFormat) open(keys *KeyRing, data, aad []byte) ([]byte, error) {
+	if f.keyID == 0 {
+		return data, nil
+	}
+
+	return keys.open(f.keyID, data, aad)
+}
+
+// binding returns the additional data the value of key is sealed with in
+// format f. Files written before version 3 seal values without any.
+func (f fileFormat) binding(key []byte) []byte {
+	if f.version < keyBoundVersion {
+		return nil
+	}
+
+	return key
+}
+
+// appendRecord encodes key and value in format f and returns the number of
+// value bytes stored. The value is only kept compressed when that makes it
+// smaller.
+func appendRecord(buf *bytes.Buffer, f fileFormat, keys *KeyRing, key, value string, compression Compression) (int, error) {
+	if f.version == legacyVersion {
+		binary.Write(buf, binary.BigEndian, int64(len(key)))
+		binary.Write(buf, binary.BigEndian, int64(len(value)))
+		buf.WriteString(key)
+		buf.WriteString(value)
+
+		return len(value), nil
+	}
+
+	flags, val := compressValue([]byte(value), compression)
+
+	return appendEntry(buf, f, keys, []byte(key), val, flags)
+}
+
+// appendPointerRecord encodes a record for key whose value is stored in a
+// blob file at p.
+func appendPointerRecord(buf *bytes.Buffer, f fileFormat, keys *KeyRing, key string, p blobPointer) error {
+	if f.version == legacyVersion {
+		return errors.New("kv: blob pointers cannot be stored in a version 1 data file")
+	}
+
+	_, err := appendEntry(buf, f, keys, []byte(key), p.encode(), blobPointerFlag)
+
+	return err
+}
+
+// compressValue compresses value when that makes it smaller and returns the
+// flags recording how it is stored.
+func compressValue(value []byte, compression Compression) (byte, []byte) {
+	if compression != NoCompression {
+		if c := compress(compression, value); len(c) < len(value) {
+			return byte(compression), c
+		}
+	}
+
+	return byte(NoCompression), value
+}
+
+func appendEntry(buf *bytes.Buffer, f fileFormat, keys *KeyRing, key, val []byte, flags byte) (int, error) {
+	k, err := f.seal(keys, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	if val, err = f.seal(keys, val, f.binding(key)); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, recordHeaderSize)
+	header[4] = flags
+	binary.BigEndian.PutUint64(header[5:13], uint64(len(k)))
+	binary.BigEndian.PutUint64(header[13:21], uint64(len(val)))
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(k)
+	crc.Write(val)
+	binary.BigEndian.PutUint32(header[:4], crc.Sum32())
+
+	buf.Write(header)
+	buf.Write(k)
+	buf.Write(val)
+
+	return len(val), nil
+}
+
+// readRecord decodes the record at offset and returns its key, its value and
+// its flags. The value of a record with blobPointerFlag set is the encoded
+// blobPointer.
+func readRecord(h *readHandle, offset int64) ([]byte, []byte, byte, error) {
+	if h.format.version == legacyVersion {
+		data := make([]byte, 16)
+
+		if _, err := h.ReadAt(data, offset); err != nil {
+			return nil, nil, 0, err
+		}
+
+		keyLength := binary.BigEndian.Uint64(data[:8])
+		valLength := binary.BigEndian.Uint64(data[8:])
+
+		if !recordFits(h, offset, 16, keyLength, valLength) {
+			return nil, nil, 0, ErrCorruptRecord
+		}
+
+		data = make([]byte, keyLength+valLength)
+		if _, err := h.ReadAt(data, offset+16); err != nil {
+			return nil, nil, 0, err
+		}
+
+		return data[:keyLength], data[keyLength:], 0, nil
+	}
+
+	header := make([]byte, recordHeaderSize)
+
+	if _, err := h.ReadAt(header, offset); err != nil {
+		return nil, nil, 0, err
+	}
+
+	keyLength := binary.BigEndian.Uint64(header[5:13])
+	valLength := binary.BigEndian.Uint64(header[13:21])
+
+	// The lengths are checked before the checksum can be, so that a corrupt
+	// header does not have a huge buffer allocated.
+	if !recordFits(h, offset, recordHeaderSize, keyLength, valLength) {
+		return nil, nil, 0, ErrCorruptRecord
+	}
+
+	data := make([]byte, keyLength+valLength)
+	if _, err := h.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, nil, 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	if crc.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		return nil, nil, 0, ErrCorruptRecord
+	}
+
+	key, err := h.format.open(h.keys, data[:keyLength], nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	val, err := h.format.open(h.keys, data[keyLength:], h.format.binding(key))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	flags := header[4]
+	if flags&blobPointerFlag != 0 {
+		return key, val, flags, nil
+	}
+
+	if val, err = decompress(Compression(flags&compressionMask), val); err != nil {
+		return nil, nil, 0, ErrCorruptRecord
+	}
+
+	return key, val, flags, nil
+}
+
+// readKeyRecord is readRecord for a record expected to be the one of key. It
+// returns ErrCorruptRecord if the record at offset holds another key.
+func readKeyRecord(h *readHandle, key string, offset int64) ([]byte, byte, error) {
+	k, val, flags, err := readRecord(h, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if string(k) != key {
+		return nil, 0, ErrCorruptRecord
+	}
+
+	return val, flags, nil
+}
+
+// maxRecordLength bounds the length of the key and of the value of a record,
+// well above anything a store holds, so that corrupt lengths are told apart
+// before the file is looked at.
+const maxRecordLength = 1 << 32
+
+// recordFits reports whether a record at offset, with a header of headerLen
+// bytes followed by a key and a value of the given lengths, lies within the
+// file read by h.
+func recordFits(h *readHandle, offset, headerLen int64, keyLength, valLength uint64) bool {
+	if keyLength > maxRecordLength || valLength > maxRecordLength {
+		return false
+	}
+
+	end := offset + headerLen + int64(keyLength+valLength)
+	if end <= int64(len(h.data)) {
+		return true
+	}
+
+	fi, err := h.file.Stat()
+	return err == nil && end <= fi.Size()
+}
+
+// recordSize returns the size of the record at offset from its header. It
+// returns ErrCorruptRecord if the header cannot be read or the record would
+// extend past limit, which is what a record torn by a crash looks like.
+func recordSize(h *readHandle, offset, limit int64) (int64, error) {
+	headerLen, lengths := int64(recordHeaderSize), 5
+	if h.format.version == legacyVersion {
+		headerLen, lengths = 16, 0
+	}
+
+	if offset+headerLen > limit {
+		return 0, ErrCorruptRecord
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := h.ReadAt(header, offset); err != nil {
+		return 0, ErrCorruptRecord
+	}
+
+	keyLength := binary.BigEndian.Uint64(header[lengths : lengths+8])
+	valLength := binary.BigEndian.Uint64(header[lengths+8 : lengths+16])
+
+	room := uint64(limit - offset - headerLen)
+	if keyLength > room || valLength > room-keyLength {
+		return 0, ErrCorruptRecord
+	}
+
+	return headerLen + int64(keyLength+valLength), nil
+}
+
+// scanRecords calls fn with every record of the data file read by h, in the
+// order they were written, up to limit bytes. err is set for records whose
+// framing is intact but that fail their checksum or cannot be decoded. The
+// scan stops at the first record that cannot be framed, and the offset it
+// stopped at is returned; anything between it and limit is a torn tail.
+func scanRecords(h *readHandle, limit int64, fn func(offset int64, key, val []byte, flags byte, err error)) int64 {
+	offset := h.format.headerSize()
+
+	for offset < limit {
+		size, err := recordSize(h, offset, limit)
+		if err != nil {
+			break
+		}
+
+		key, val, flags, err := readRecord(h, offset)
+		fn(offset, key, val, flags, err)
+
+		offset += size
+	}
+
+	return offset
+}
+
+// scanIndex calls fn with every entry of the index file r in format f, in
+// the order they were written, up to limit bytes. err is set for entries
+// whose key cannot be opened. Like scanRecords it stops at the first entry
+// that cannot be framed and returns the offset it stopped at.
+func scanIndex(r io.ReaderAt, f fileFormat, keys *KeyRing, limit int64, fn func(key string, offset int64, err error)) int64 {
+	offset := f.headerSize()
+
+	for offset+16 <= limit {
+		header := make([]byte, 16)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			break
+		}
+
+		keyLength := binary.BigEndian.Uint64(header[:8])
+		if keyLength > uint64(limit-offset-16) {
+			break
+		}
+
+		data := make([]byte, keyLength)
+		if _, err := r.ReadAt(data, offset+16); err != nil {
+			break
+		}
+
+		key, err := f.open(keys, data, nil)
+		fn(string(key), int64(binary.BigEndian.Uint64(header[8:])), err)
+
+		offset += 16 + int64(keyLength)
+	}
+
+	return offset
+}
+
+// appendIndexRecord encodes an index entry in format f.
+func appendIndexRecord(buf *bytes.Buffer, f fileFormat, keys *KeyRing, key string, offset int64) error {
+	k, err := f.seal(keys, []byte(key), nil)
+	if err != nil {
+		return err
+	}
+
+	binary.Write(buf, binary.BigEndian, int64(len(k)))
+	binary.Write(buf, binary.BigEndian, offset)
+	buf.Write(k)
+
+	return nil
+}
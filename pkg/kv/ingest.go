@@ -0,0 +1,231 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Ingest loads the dump read from r in the given format into the store
+// without going through the memtable, which makes it much faster than
+// setting the entries one by one when seeding a store. It returns the number
+// of entries ingested.
+//
+// The entries are written straight to a new data file and index file along
+// with the live entries of the store, and the new files then replace the
+// store's ones the way a compaction does. Readers see either none or all of
+// the ingested entries, and so does the store if it crashes meanwhile. The
+// dump need not be sorted; a key that appears more than once keeps its last
+// value. Ingested values replace the ones keys had when Ingest was called,
+// while writes made during the ingest win over them.
+func (kv *KV) Ingest(r io.Reader, format DumpFormat, opts ...DumpOption) (int, error) {
+	return kv.IngestContext(context.Background(), r, format, opts...)
+}
+
+// IngestContext is Ingest, giving up with the error of ctx once it is done.
+// The store is left as it was.
+func (kv *KV) IngestContext(ctx context.Context, r io.Reader, format DumpFormat, opts ...DumpOption) (int, error) {
+	if kv.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// In-memory stores have no files to build.
+	if kv.inMemory {
+		return kv.ImportContext(ctx, r, format, opts...)
+	}
+
+	staged, err := kv.StageIngestContext(ctx, r, format, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := staged.Install(); err != nil {
+		return 0, err
+	}
+
+	return staged.Ingested(), nil
+}
+
+// StagedIngest is an ingest whose files are written but do not replace the
+// store's ones until it is installed. Flushes and compactions wait until it
+// is installed or aborted, so one of the two must be called.
+type StagedIngest struct {
+	kv   *KV
+	w    *rewriter
+	n    int
+	done bool
+}
+
+// StageIngest writes the files Ingest would install for the dump read from r
+// without installing them. It returns ErrInMemory for in-memory stores.
+func (kv *KV) StageIngest(r io.Reader, format DumpFormat, opts ...DumpOption) (*StagedIngest, error) {
+	return kv.StageIngestContext(context.Background(), r, format, opts...)
+}
+
+// StageIngestContext is StageIngest, giving up with the error of ctx once it
+// is done.
+func (kv *KV) StageIngestContext(ctx context.Context, r io.Reader, format DumpFormat, opts ...DumpOption) (*StagedIngest, error) {
+	if kv.readOnly {
+		return nil, ErrReadOnly
+	}
+	if kv.inMemory {
+		return nil, ErrInMemory
+	}
+
+	if log.GetLevel() == log.DebugLevel {
+		defer TimeTrack(time.Now(), "StageIngest")
+	}
+
+	// Entries set before the ingest are flushed so that the ingested values
+	// replace them.
+	if err := kv.SyncToDisk(); err != nil {
+		return nil, err
+	}
+
+	kv.flushLock.Lock()
+
+	staged, err := kv.stageIngest(ctx, r, format, opts...)
+	if err != nil {
+		kv.flushLock.Unlock()
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+func (kv *KV) stageIngest(ctx context.Context, r io.Reader, format DumpFormat, opts ...DumpOption) (*StagedIngest, error) {
+	kv.lock.RLock()
+	current := make(map[string]Index, len(kv.index))
+	for k, v := range kv.index {
+		current[k] = v
+	}
+	h, err := kv.files.acquire(kv.dbPath)
+	kv.lock.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+	defer kv.files.release(h)
+
+	w, err := kv.newRewriter()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &StagedIngest{kv: kv, w: w}
+	if err := staged.write(ctx, h, current, NewDumpReader(r, format, opts...)); err != nil {
+		w.close()
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+// write writes the entries of the dump read by d followed by the entries of
+// current, read from h, that the dump does not hold.
+func (s *StagedIngest) write(ctx context.Context, h *readHandle, current map[string]Index, d *DumpReader) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key, value, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.w.writeValue(key, value); err != nil {
+			return err
+		}
+		s.n++
+	}
+
+	// Keys the dump does not hold keep their values.
+	for k, v := range current {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, ok := s.w.index[k]; ok {
+			continue
+		}
+		if err := s.w.copyRecord(h, k, v.Offset); err != nil {
+			return err
+		}
+	}
+
+	// Values written to blob files are read back by Iterate.
+	return s.w.blobs.close()
+}
+
+// Ingested returns the number of entries of the dump.
+func (s *StagedIngest) Ingested() int {
+	return s.n
+}
+
+// Iterate calls fn with every key and value of the staged files, in key
+// order, until fn returns false. They are read from disk one at a time, so
+// the store need not fit in memory. Writes made to the store since the
+// ingest was staged are not included.
+func (s *StagedIngest) Iterate(fn func(key, value string) bool) error {
+	f, err := os.Open(s.kv.dbPath + compactedSuffix)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := &readHandle{path: f.Name(), file: f, format: s.w.format, keys: s.kv.keys}
+
+	keys := make([]string, 0, len(s.w.index))
+	for k := range s.w.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value, err := s.kv.readValue(h, k, s.w.index[k].Offset)
+		if err != nil {
+			return err
+		}
+		if !fn(k, value) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Install makes the staged files the files of the store.
+func (s *StagedIngest) Install() error {
+	if s.done {
+		return errors.New("kv: ingest is already installed or aborted")
+	}
+	s.done = true
+
+	defer s.kv.flushLock.Unlock()
+	defer s.w.close()
+
+	_, err := s.w.install()
+
+	return err
+}
+
+// Abort removes the staged files, leaving the store as it was. It does
+// nothing once the ingest is installed.
+func (s *StagedIngest) Abort() {
+	if s.done {
+		return
+	}
+	s.done = true
+
+	s.w.close()
+	s.kv.flushLock.Unlock()
+}
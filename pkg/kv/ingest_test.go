@@ -0,0 +1,142 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIngest(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+
+	store.Set("kept", "old")
+	store.Set("replaced", "old")
+	store.Set("deleted", "old")
+	store.Delete("deleted")
+	store.Set("large_kept", largeValue(1, 2048))
+
+	// An unsorted dump, with a key that appears twice.
+	buf := bytes.NewBuffer([]byte{})
+	d := NewDumpWriter(buf, BinaryDump)
+	for i := 99; i >= 0; i-- {
+		d.Write(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	d.Write("replaced", "first")
+	d.Write("large", largeValue(2, 2048))
+	d.Write("replaced", "new")
+	d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := store.IngestContext(ctx, bytes.NewReader(buf.Bytes()), BinaryDump)
+	assetEqual(t, "cancelled err", context.Canceled, err)
+	_, ok := store.Get("key_0")
+	assetEqual(t, "key_0 after cancelled ingest", false, ok)
+	_, err = os.Stat(dbPath + compactedSuffix)
+	assetEqual(t, "files of cancelled ingest removed", true, os.IsNotExist(err))
+
+	n, err := store.Ingest(bytes.NewReader(buf.Bytes()), BinaryDump)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ingested", 103, n)
+
+	check := func(name string) {
+		for i := 0; i < 100; i++ {
+			value, _ := store.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("%s key_%d", name, i), fmt.Sprintf("value_%d", i), value)
+		}
+
+		value, _ := store.Get("kept")
+		assetEqual(t, name+" kept", "old", value)
+		value, _ = store.Get("replaced")
+		assetEqual(t, name+" replaced", "new", value)
+		value, _ = store.Get("large")
+		assetEqual(t, name+" large", largeValue(2, 2048), value)
+		value, _ = store.Get("large_kept")
+		assetEqual(t, name+" large_kept", largeValue(1, 2048), value)
+		_, ok := store.Get("deleted")
+		assetEqual(t, name+" deleted", false, ok)
+	}
+	check("ingested")
+
+	store.Close()
+	store = NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	check("reopened")
+}
+
+func TestStageIngest(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	store.Set("kept", "old")
+	store.Set("replaced", "old")
+
+	buf := bytes.NewBuffer([]byte{})
+	d := NewDumpWriter(buf, JSONLines)
+	d.Write("replaced", "new")
+	d.Write("large", largeValue(1, 2048))
+	d.Close()
+	dump := buf.Bytes()
+
+	staged, err := store.StageIngest(bytes.NewReader(dump), JSONLines)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ingested", 2, staged.Ingested())
+
+	items := make(map[string]string)
+	err = staged.Iterate(func(key, value string) bool {
+		items[key] = value
+		return true
+	})
+	assetEqual(t, "iterate err", nil, err)
+	assetEqual(t, "staged items", 3, len(items))
+	assetEqual(t, "staged kept", "old", items["kept"])
+	assetEqual(t, "staged replaced", "new", items["replaced"])
+	assetEqual(t, "staged large", largeValue(1, 2048), items["large"])
+
+	// Nothing changes until the ingest is installed.
+	value, _ := store.Get("replaced")
+	assetEqual(t, "replaced before install", "old", value)
+
+	staged.Abort()
+	staged.Abort()
+	value, _ = store.Get("replaced")
+	assetEqual(t, "replaced after abort", "old", value)
+	_, err = os.Stat(dbPath + compactedSuffix)
+	assetEqual(t, "files of aborted ingest removed", true, os.IsNotExist(err))
+
+	staged, err = store.StageIngest(bytes.NewReader(dump), JSONLines)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "install", nil, staged.Install())
+	staged.Abort()
+
+	value, _ = store.Get("replaced")
+	assetEqual(t, "replaced after install", "new", value)
+	value, _ = store.Get("large")
+	assetEqual(t, "large after install", largeValue(1, 2048), value)
+
+	// Flushes go on once the ingest is installed.
+	store.Set("after", "value")
+	assetEqual(t, "sync", nil, store.SyncToDisk())
+
+	memory := NewMemoryKV()
+	defer memory.Close()
+	_, err = memory.StageIngest(bytes.NewReader(dump), JSONLines)
+	assetEqual(t, "in memory", ErrInMemory, err)
+}
@@ -2,11 +2,13 @@ package kv
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -16,105 +18,445 @@ type Index struct {
 	Offset int64
 }
 
+var ErrReadOnly = errors.New("kv: store is opened read-only")
+
+// KV is an on-disk key-value store. Writes go to an in-memory memtable. Once
+// it grows past memTableSize bytes it becomes immutable and is queued for a
+// background goroutine to append it to the data file, while writes continue
+// on a fresh memtable.
+//
+// lock guards the memtable pointers, the index and the current data file.
+// It is only held for in-memory work; file I/O happens outside of it.
+// flushLock serializes everything that appends to or replaces the data,
+// index and blob files, and also guards offset and the current blob file.
+// blobLock is held for reading by readers that may follow a pointer into a
+// blob file and for writing while blob files are removed.
+//
+// The files that make up the store are recorded in a manifest; see
+// manifest.go.
 type KV struct {
-	Offset         int64
-	Index          map[string]Index
-	MemIndex       map[string]Index
-	MemTable       map[string]string
+	offset         int64
+	index          map[string]Index
+	active         *memTable
+	immutables     []*memTable
 	dbPath         string
 	indexPath      string
 	blockSize      uint32
 	maxBlockNumber int16
-	Lock           sync.RWMutex
+	lock           sync.RWMutex
+	flushLock      sync.Mutex
 	isCompacting   Bool
+	fileID         uint64
+	cache          *Cache
+	cacheSize      int64
+	files          *fdCache
+	maxOpenFiles   int
+	mmap           bool
+	format         fileFormat
+	indexFormat    fileFormat
+	keys           *KeyRing
+	compression    Compression
+	rawBytes       int64
+	storedBytes    int64
+
+	userBytes   int64
+	diskBytes   int64
+	gets        uint64
+	diskReads   uint64
+	statsLock   sync.Mutex
+	flushes     OperationStats
+	compactions OperationStats
+
+	events   *eventQueue
+	watchers watchers
+
+	inMemory         bool
+	snapshotPath     string
+	snapshotInterval time.Duration
+	snapshotLock     sync.Mutex
+	snapshotStop     chan struct{}
+	snapshotDone     chan struct{}
+
+	valueThreshold  int
+	maxBlobFileSize int64
+	blobFile        uint64
+	blobOffset      int64
+	blobFormat      fileFormat
+	blobLock        sync.RWMutex
+	blobFileList    []uint64
+
+	manifest    *manifest
+	version     uint64
+	indexOffset int64
+	readOnly    bool
+	maintenance bool
+	pinned      []*readHandle
+	dirLock     *fileLock
+
+	memTableSize          int64
+	maxImmutableMemTables int
+	flushed               *sync.Cond
+	flushC                chan struct{}
+	flushErr              error
+	flusherDone           chan struct{}
+	isClosed              Bool
 }
 
-func NewKV(dbPath, indexPath string, blockSize uint32, maxBlockNumber int16) *KV {
-	kv := new(KV)
-	kv.dbPath = dbPath
-	kv.indexPath = indexPath
+// NewKV opens the store kept in the data file at dbPath and the index file at
+// indexPath, creating them if needed. blockSize and maxBlockNumber are no
+// longer used; the memtable is flushed based on the size set with
+// WithMemTableSize. It panics if the store cannot be opened; see OpenKV.
+func NewKV(dbPath, indexPath string, blockSize uint32, maxBlockNumber int16, opts ...Option) *KV {
+	kv, err := OpenKV(dbPath, indexPath, opts...)
+	if err != nil {
+		panic(err)
+	}
+
 	kv.blockSize = blockSize
-	kv.Index = make(map[string]Index)
-	kv.MemIndex = make(map[string]Index)
-	kv.MemTable = make(map[string]string)
 	kv.maxBlockNumber = maxBlockNumber
-	kv.isCompacting = NewBool()
 
-	kv.isCompacting.Set(false)
+	return kv
+}
+
+// OpenKV opens the store kept in the data file at dbPath and the index file
+// at indexPath like NewKV, but returns an error instead of panicking when the
+// store cannot be opened: when it is locked by another process (ErrLocked),
+// when its files cannot be read or recovered, or, in read-only mode, when it
+// does not exist.
+func OpenKV(dbPath, indexPath string, opts ...Option) (*KV, error) {
+	kv := newKV(dbPath, indexPath, opts...)
+
+	var lock *fileLock
+	var err error
+	if kv.maintenance {
+		lock, err = lockDirExclusive(filepath.Dir(kv.dbPath))
+	} else {
+		lock, err = lockDir(filepath.Dir(kv.dbPath), kv.readOnly)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kv.dirLock = lock
+
+	if kv.readOnly {
+		if err := kv.openReadOnly(); err != nil {
+			kv.releasePinned()
+			kv.files.close()
+			lock.unlock()
+			return nil, err
+		}
+
+		kv.events.start()
+		return kv, nil
+	}
+
+	if err := kv.openManifest(); err != nil {
+		lock.unlock()
+		return nil, err
+	}
+
+	fail := func(err error) (*KV, error) {
+		kv.files.close()
+		kv.manifest.close()
+		lock.unlock()
+		return nil, err
+	}
 
 	f, err := os.OpenFile(kv.dbPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		panic(err)
+		return fail(err)
 	}
 	defer f.Close()
 
 	st, err := f.Stat()
 	if err != nil {
-		panic(err)
+		return fail(err)
+	}
+
+	kv.offset = st.Size()
+	kv.format = kv.newFormat()
+
+	if kv.offset == 0 {
+		if _, err := f.Write(fileHeader(dataFileMagic, kv.format.keyID)); err != nil {
+			return fail(err)
+		}
+		if err := f.Sync(); err != nil {
+			return fail(err)
+		}
+		kv.offset = fileHeaderSize
+	} else if r, err := os.Open(kv.dbPath); err == nil {
+		// Existing files keep being appended in the format and with the key
+		// they were created with until a compaction rewrites them.
+		kv.format = readFormat(r, dataFileMagic)
+		r.Close()
+	}
+
+	if err := kv.checkKey(kv.dbPath, kv.format); err != nil {
+		return fail(err)
+	}
+
+	intact, err := kv.loadIndex(-1)
+	if err != nil {
+		return fail(err)
+	}
+	if err := kv.loadBlobFiles(); err != nil {
+		return fail(err)
+	}
+
+	// Repair checks the index as found instead.
+	if !kv.maintenance {
+		if err := kv.recoverIndex(intact); err != nil {
+			return fail(err)
+		}
 	}
 
-	kv.Offset = st.Size()
+	if err := kv.commit(); err != nil {
+		return fail(err)
+	}
 
-	kv.loadIndex()
+	kv.events.start()
+	go kv.flusher()
+
+	return kv, nil
+}
+
+// newKV sets up the in-memory state of a store, before anything is loaded.
+func newKV(dbPath, indexPath string, opts ...Option) *KV {
+	kv := new(KV)
+	kv.cacheSize = DefaultCacheSize
+	kv.maxOpenFiles = DefaultMaxOpenFiles
+	kv.memTableSize = DefaultMemTableSize
+	kv.maxImmutableMemTables = DefaultMaxImmutableMemTables
+	kv.valueThreshold = DefaultValueThreshold
+	kv.maxBlobFileSize = DefaultMaxBlobFileSize
+
+	for _, opt := range opts {
+		opt(kv)
+	}
+
+	if kv.maxImmutableMemTables < 1 {
+		kv.maxImmutableMemTables = 1
+	}
+
+	if kv.cacheSize > 0 {
+		kv.cache = NewCache(kv.cacheSize)
+	}
+	kv.files = newFDCache(kv.maxOpenFiles, kv.mmap, kv.keys)
+
+	kv.dbPath = dbPath
+	kv.indexPath = indexPath
+	kv.index = make(map[string]Index)
+	kv.active = newMemTable()
+	kv.isCompacting = NewBool()
+
+	kv.isCompacting.Set(false)
+	kv.isClosed = NewBool()
+	kv.flushed = sync.NewCond(&kv.lock)
+	kv.flushC = make(chan struct{}, 1)
+	kv.flusherDone = make(chan struct{})
 
 	return kv
 }
 
-func (kv *KV) loadIndex() {
-	f, err := os.Open(kv.indexPath)
+// recoverIndex rebuilds the index from the data file if it could not be read
+// in full or does not cover all of the data file.
+func (kv *KV) recoverIndex(intact bool) error {
+	h, err := kv.files.acquire(kv.dbPath)
 	if err != nil {
-		return
+		return err
 	}
-	defer f.Close()
+	defer kv.files.release(h)
 
-	st, err := f.Stat()
-	if err != nil {
-		panic(err)
+	if intact && !kv.indexBehind(h) {
+		return nil
 	}
 
-	size := st.Size()
+	if !intact {
+		kv.events.push(func(l EventListener) {
+			l.OnCorruption(CorruptionInfo{Path: kv.indexPath, Offset: -1, Err: ErrCorruptRecord})
+		})
+	}
+
+	log.Warn("Index ", kv.indexPath, " does not match ", kv.dbPath, "; rebuilding it from the data file")
 
-	var offset int64
+	return kv.rebuildIndex(h)
+}
+
+var errStoreChanged = errors.New("kv: store changed while it was being opened")
+
+// openReadOnly loads the store as of its last commit without writing to any
+// of its files. The data and blob files are kept open for as long as the
+// store is, so a writer compacting them away does not affect it. Opening is
+// retried if the writer replaces the files while they are being loaded.
+func (kv *KV) openReadOnly() error {
+	for attempt := 0; attempt < 10; attempt++ {
+		err := kv.loadReadOnly()
+		if err != errStoreChanged {
+			if err == nil {
+				close(kv.flusherDone)
+			}
+			return err
+		}
+		kv.releasePinned()
+	}
 
-	for offset < size {
-		f.Seek(offset, 0)
+	return errStoreChanged
+}
 
-		data := make([]byte, 16)
+func (kv *KV) loadReadOnly() error {
+	v, err := kv.loadManifest()
+	if err != nil {
+		return err
+	}
+	kv.blobFileList = v.BlobFiles
 
-		_, err = f.Read(data)
+	h, err := kv.files.acquire(kv.dbPath)
+	if err != nil {
+		return err
+	}
+	kv.pinned = append(kv.pinned, h)
+	kv.format = h.format
 
+	kv.offset = v.DataSize
+	if kv.offset < 0 {
+		st, err := h.file.Stat()
 		if err != nil {
-			log.Error("Error: ", err)
+			return err
 		}
+		kv.offset = st.Size()
+	}
 
-		keyLength := binary.BigEndian.Uint64(data[:8])
-		valLength := uint64(8)
+	if err := kv.checkKey(kv.dbPath, kv.format); err != nil {
+		return err
+	}
 
-		data = make([]byte, keyLength+valLength)
-		f.Seek(int64(offset+8), 0)
-		_, err = f.Read(data)
+	// Index records past the committed size belong to a flush that may not
+	// have completed; a writer would truncate them.
+	kv.index = make(map[string]Index)
+	intact, err := kv.loadIndex(v.IndexSize)
+	if err != nil {
+		return err
+	}
 
+	for _, file := range kv.blobFileList {
+		bh, err := kv.files.acquire(kv.blobPath(file))
+		if os.IsNotExist(err) {
+			return errStoreChanged
+		}
 		if err != nil {
-			log.Error("Error: ", err)
+			return err
 		}
+		kv.pinned = append(kv.pinned, bh)
+	}
+	if err := kv.loadBlobFiles(); err != nil {
+		return err
+	}
+
+	if !intact || kv.indexBehind(h) {
+		// The index can only be rebuilt in memory without writing to the
+		// store.
+		log.Warn("Index ", kv.indexPath, " does not match ", kv.dbPath, "; rebuilding it in memory")
+		kv.index, _ = kv.indexFromData(h)
+	}
+
+	// A compaction renames the data file into place before the index, so if
+	// the data file is still the one loaded, so was the index.
+	pinned, err := h.file.Stat()
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(kv.dbPath); err != nil || !os.SameFile(st, pinned) {
+		return errStoreChanged
+	}
+
+	return nil
+}
 
-		ofs := binary.BigEndian.Uint64(data[:8])
-		key := string(data[8:])
+func (kv *KV) releasePinned() {
+	for _, h := range kv.pinned {
+		kv.files.release(h)
+	}
+	kv.pinned = nil
+}
+
+// newFormat returns the format new data and index files are written in.
+func (kv *KV) newFormat() fileFormat {
+	return fileFormat{version: currentVersion, keyID: kv.keys.ActiveKey()}
+}
 
-		kv.Index[key] = Index{int64(ofs)}
+// checkKey returns an error wrapping ErrUnknownKey if the file at path, of
+// the given format, is encrypted with a key missing from the key ring.
+func (kv *KV) checkKey(path string, format fileFormat) error {
+	if format.keyID == 0 {
+		return nil
+	}
 
-		offset += 8 + int64(keyLength+valLength)
+	if kv.keys == nil || kv.keys.keys[format.keyID] == nil {
+		return fmt.Errorf("%s is encrypted with key %d: %w", path, format.keyID, ErrUnknownKey)
 	}
+
+	return nil
 }
 
-func (kv *KV) Set(key, value string) {
+// loadIndex reads the index file, stopping at limit bytes unless limit is
+// negative. It returns false if some of the entries could not be read.
+func (kv *KV) loadIndex(limit int64) (bool, error) {
+	kv.indexFormat = kv.newFormat()
+
+	f, err := os.Open(kv.indexPath)
+	if err != nil {
+		return true, nil
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	size := st.Size()
+	if limit >= 0 && size > limit {
+		size = limit
+	}
+	kv.indexOffset = size
+	if size == 0 {
+		return true, nil
+	}
+
+	kv.indexFormat = readFormat(f, indexFileMagic)
+	if err := kv.checkKey(kv.indexPath, kv.indexFormat); err != nil {
+		return false, err
+	}
+
+	intact := true
+	end := scanIndex(f, kv.indexFormat, kv.keys, size, func(key string, offset int64, err error) {
+		if err != nil {
+			log.Error("Error: ", err)
+			intact = false
+			return
+		}
+
+		kv.index[key] = Index{offset}
+	})
+
+	if end < size {
+		log.Warn(kv.indexPath, " has ", size-end, " unreadable bytes at offset ", end)
+		intact = false
+	}
+
+	return intact, nil
+}
+
+func (kv *KV) Set(key, value string) error {
 	if log.GetLevel() == log.DebugLevel {
 		defer TimeTrack(time.Now(), fmt.Sprintf("Set `%s` with value `%s`", key, value))
 	}
 
-	kv.Lock.Lock()
-	set(kv, key, value)
-	kv.Lock.Unlock()
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	return set(kv, key, value)
 }
 
 func (kv *KV) Get(key string) (string, bool) {
@@ -122,26 +464,35 @@ func (kv *KV) Get(key string) (string, bool) {
 		defer TimeTrack(time.Now(), fmt.Sprintf("Get `%s`", key))
 	}
 
-	kv.Lock.RLock()
-	val, ok := get(kv, key)
-	kv.Lock.RUnlock()
-
-	return val, ok
+	return get(kv, key)
 }
 
-func (kv *KV) Delete(key string) {
+func (kv *KV) Delete(key string) error {
 	if log.GetLevel() == log.DebugLevel {
 		defer TimeTrack(time.Now(), fmt.Sprintf("Delete `%s`", key))
 	}
 
-	kv.Lock.Lock()
-	del(kv, key)
-	kv.Lock.Unlock()
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	return del(kv, key)
 }
 
 func get(kv *KV, key string) (string, bool) {
-	val, ok := kv.MemTable[key]
+	atomic.AddUint64(&kv.gets, 1)
+
+	kv.blobLock.RLock()
+	defer kv.blobLock.RUnlock()
+
+	kv.lock.RLock()
+
+	val, ok := kv.active.get(key)
+	for i := len(kv.immutables) - 1; !ok && i >= 0; i-- {
+		val, ok = kv.immutables[i].get(key)
+	}
 	if ok {
+		kv.lock.RUnlock()
 		log.Info(fmt.Sprintf("Key: %s found in memory", key))
 
 		if val == "__KVGO_TOMBSTONE__" {
@@ -150,239 +501,730 @@ func get(kv *KV, key string) (string, bool) {
 		return val, ok
 	}
 
-	f, err := os.Open(kv.dbPath)
+	indexVal, ok := kv.index[key]
+
+	if !ok {
+		kv.lock.RUnlock()
+		return "", false
+	}
+
+	fileID := kv.fileID
+
+	if kv.cache != nil {
+		if value, ok := kv.cache.Get(fileID, indexVal.Offset); ok {
+			kv.lock.RUnlock()
+			if value == "__KVGO_TOMBSTONE__" {
+				return "", false
+			}
+			return value, true
+		}
+	}
+
+	// The handle is taken while the index is still locked so it belongs to
+	// the same file as indexVal even if a compaction replaces it afterwards.
+	h, err := kv.files.acquire(kv.dbPath)
+	kv.lock.RUnlock()
+
 	if err != nil {
 		return "", false
 	}
-	defer f.Close()
+	defer kv.files.release(h)
 
-	value := ""
+	atomic.AddUint64(&kv.diskReads, 1)
+	value, err := kv.readValue(h, key, indexVal.Offset)
+	if err != nil {
+		log.Error("Error: ", err)
+		kv.corruption(kv.dbPath, indexVal.Offset, err)
+		return "", false
+	}
 
-	indexVal, ok := kv.Index[key]
+	if kv.cache != nil {
+		kv.cache.Set(fileID, indexVal.Offset, value)
+	}
 
-	if !ok {
+	if value == "__KVGO_TOMBSTONE__" {
 		return "", false
 	}
 
-	f.Seek(int64(indexVal.Offset), 0)
+	return value, true
+}
 
-	data := make([]byte, 16)
+// readValue returns the value of key from its record at offset in h,
+// following the record to its blob file if the value is stored there.
+func (kv *KV) readValue(h *readHandle, key string, offset int64) (string, error) {
+	value, flags, err := readKeyRecord(h, key, offset)
+	if err != nil {
+		return "", err
+	}
 
-	_, err = f.Read(data)
+	if flags&blobPointerFlag == 0 {
+		return string(value), nil
+	}
 
+	p, err := decodeBlobPointer(value)
 	if err != nil {
-		log.Error("Error: ", err)
+		return "", err
+	}
+
+	return kv.readBlob(key, p)
+}
+
+func set(kv *KV, key, value string) error {
+	atomic.AddInt64(&kv.userBytes, int64(len(key)+len(value)))
+
+	for {
+		kv.lock.RLock()
+		mt := kv.active
+
+		// The memtable is not rotated during a compaction as nothing can be
+		// flushed until it is done; it keeps growing instead. In-memory
+		// stores never rotate it: it holds all of their data.
+		if mt.bytes() < kv.memTableSize || kv.isCompacting.Value() || kv.inMemory {
+			kv.change(key, value, func() { mt.set(key, value) })
+			kv.lock.RUnlock()
+			return nil
+		}
+		kv.lock.RUnlock()
+
+		if err := kv.rotate(mt); err != nil {
+			return err
+		}
 	}
+}
 
-	keyLength := binary.BigEndian.Uint64(data[:8])
-	valLength := binary.BigEndian.Uint64(data[8:])
+func del(kv *KV, key string) error {
+	if kv.inMemory {
+		atomic.AddInt64(&kv.userBytes, int64(len(key)))
 
-	data = make([]byte, keyLength+valLength)
-	f.Seek(int64(indexVal.Offset+16), 0)
-	_, err = f.Read(data)
+		kv.lock.RLock()
+		mt := kv.active
+		kv.change(key, "__KVGO_TOMBSTONE__", func() { mt.delete(key) })
+		kv.lock.RUnlock()
+		return nil
+	}
 
-	if err != nil {
-		log.Error("Error: ", err)
+	return set(kv, key, "__KVGO_TOMBSTONE__")
+}
+
+// rotate queues mt for flushing and replaces it with an empty memtable. If
+// too many memtables are already waiting to be flushed it blocks until the
+// flusher catches up, or returns the error of the last flush if it failed.
+// Nothing is done if mt has already been rotated.
+func (kv *KV) rotate(mt *memTable) error {
+	kv.lock.Lock()
+
+	if kv.active == mt && len(kv.immutables) >= kv.maxImmutableMemTables && kv.flushErr != nil {
+		err := kv.flushErr
+		kv.lock.Unlock()
+		return err
 	}
 
-	value = string(data[keyLength:])
-	if value == "__KVGO_TOMBSTONE__" {
-		return "", false
+	if kv.active == mt && len(kv.immutables) >= kv.maxImmutableMemTables {
+		start := time.Now()
+		log.Warn("Stalling writes: ", len(kv.immutables), " memtables are waiting to be flushed")
+		info := StallInfo{ImmutableMemTables: len(kv.immutables), MaxImmutableMemTables: kv.maxImmutableMemTables}
+		kv.notifyStallBegin(info)
+
+		for kv.active == mt && len(kv.immutables) >= kv.maxImmutableMemTables && kv.flushErr == nil {
+			kv.flushed.Wait()
+		}
+
+		info.Duration = time.Since(start)
+		log.Warn("Writes were stalled for ", info.Duration)
+		kv.notifyStallEnd(info)
+
+		if kv.active == mt && len(kv.immutables) >= kv.maxImmutableMemTables {
+			err := kv.flushErr
+			kv.lock.Unlock()
+			return err
+		}
 	}
 
-	return value, true
+	if kv.active != mt || mt.len() == 0 {
+		kv.lock.Unlock()
+		return nil
+	}
+
+	kv.immutables = append(kv.immutables, mt)
+	kv.active = newMemTable()
+	kv.lock.Unlock()
+
+	select {
+	case kv.flushC <- struct{}{}:
+	default:
+	}
+
+	return nil
 }
 
-func set(kv *KV, key, value string) {
-	kv.MemTable[key] = value
+// flushRetryInterval is how long the flusher waits before trying a failed
+// flush again.
+var flushRetryInterval = time.Second
+
+func (kv *KV) flusher() {
+	defer close(kv.flusherDone)
 
-	if !kv.isCompacting.Value() && uint32(len(kv.MemTable)) == kv.blockSize {
-		kv.SyncToDisk()
+	var retry <-chan time.Time
+	for {
+		select {
+		case _, ok := <-kv.flushC:
+			if !ok {
+				return
+			}
+		case <-retry:
+		}
+
+		retry = nil
+		if err := kv.flushImmutables(); err != nil {
+			retry = time.After(flushRetryInterval)
+		}
 	}
 }
 
-func del(kv *KV, key string) {
-	kv.MemTable[key] = "__KVGO_TOMBSTONE__"
+// flushImmutables writes the queued immutable memtables to disk, oldest
+// first. If one cannot be written it stays queued, along with those after
+// it, and the error is returned. Until a flush succeeds again writers that
+// would have to wait for the flusher fail with the error instead.
+func (kv *KV) flushImmutables() error {
+	kv.flushLock.Lock()
+	defer kv.flushLock.Unlock()
+
+	for {
+		kv.lock.RLock()
+		if len(kv.immutables) == 0 {
+			kv.lock.RUnlock()
+			return nil
+		}
+		mt := kv.immutables[0]
+		kv.lock.RUnlock()
 
-	if !kv.isCompacting.Value() && uint32(len(kv.MemTable)) == kv.blockSize {
-		kv.SyncToDisk()
+		err := kv.writeMemTable(mt)
+
+		kv.lock.Lock()
+		kv.flushErr = err
+		if err != nil {
+			// Wakes up the writers stalled on the flusher to fail.
+			kv.flushed.Broadcast()
+		}
+		kv.lock.Unlock()
+
+		if err != nil {
+			kv.backgroundError("flush", err)
+			return err
+		}
 	}
 }
 
-func (kv *KV) SyncToDisk() {
+// SyncToDisk flushes the memtable and everything queued for flushing and
+// returns once it is all on disk.
+func (kv *KV) SyncToDisk() error {
 	if log.GetLevel() == log.DebugLevel {
 		defer TimeTrack(time.Now(), "SyncToDisk")
 	}
 
-	if len(kv.MemTable) == 0 {
-		return
+	if kv.readOnly {
+		return ErrReadOnly
 	}
 
+	if kv.inMemory {
+		return kv.writeSnapshot()
+	}
+
+	kv.lock.Lock()
+	if kv.active.len() > 0 {
+		kv.immutables = append(kv.immutables, kv.active)
+		kv.active = newMemTable()
+	}
+	kv.lock.Unlock()
+
+	return kv.flushImmutables()
+}
+
+// writeMemTable appends mt to the data and index files and publishes the new
+// offsets. If that fails the files are cut back to where they ended, mt is
+// left queued and the error is returned. The caller must hold flushLock.
+func (kv *KV) writeMemTable(mt *memTable) error {
+	start := time.Now()
+	info := FlushInfo{Entries: mt.len(), MemTableBytes: mt.bytes(), StartTime: start}
+	kv.notifyFlushBegin(info)
+	written := atomic.LoadInt64(&kv.diskBytes)
+	dataOffset, indexOffset := kv.offset, kv.indexOffset
+
+	memIndex, err := kv.appendMemTable(mt)
+	if err == nil {
+		err = kv.syncMemIndexToDisk(memIndex)
+	}
+	if err == nil {
+		err = kv.commit()
+	}
+	if err != nil {
+		kv.truncateFlush(dataOffset, indexOffset)
+
+		info.BytesWritten = atomic.LoadInt64(&kv.diskBytes) - written
+		info.Duration = time.Since(start)
+		info.Err = err
+		kv.notifyFlushEnd(info)
+		return err
+	}
+
+	kv.lock.Lock()
+	for k, v := range memIndex {
+		kv.index[k] = v
+	}
+	if len(kv.immutables) > 0 && kv.immutables[0] == mt {
+		kv.immutables = kv.immutables[1:]
+	}
+	kv.flushed.Broadcast()
+	kv.lock.Unlock()
+
+	kv.trackOperation(&kv.flushes, start)
+
+	info.BytesWritten = atomic.LoadInt64(&kv.diskBytes) - written
+	info.Duration = time.Since(start)
+	kv.notifyFlushEnd(info)
+
+	return nil
+}
+
+// appendMemTable appends the records of mt to the data file, and their large
+// values to blob files, syncs them and returns the offsets of the records. It
+// stops at the first error.
+func (kv *KV) appendMemTable(mt *memTable) (map[string]Index, error) {
+	dataOffset := kv.offset
+
 	f, err := os.OpenFile(kv.dbPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer f.Close()
 
-	for k, v := range kv.MemTable {
-		kv.Index[k] = Index{kv.Offset}
-		kv.MemIndex[k] = Index{kv.Offset}
-		buf := bytes.NewBuffer([]byte{})
+	if kv.mmap {
+		// The mapping only covers the file as it was when it was made, so the
+		// handle is retired to have the flushed block mapped on the next read.
+		defer kv.files.retire(kv.dbPath)
+	}
 
-		kv.Offset += 16
-		if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(k)))); err != nil {
-			return
-		}
-		if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(v)))); err != nil {
-			return
-		}
+	memIndex := make(map[string]Index)
 
-		if _, err = buf.Write([]byte(k)); err != nil {
-			return
-		}
-		kv.Offset += int64(len(k))
+	buf := bytes.NewBuffer([]byte{})
+	blobs := kv.newBlobWriter()
+	defer blobs.close()
 
-		if _, err = buf.Write([]byte(v)); err != nil {
-			return
+	for k, v := range mt.items() {
+		buf.Reset()
+		stored, err := kv.appendValue(buf, blobs, kv.format, k, v)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %q: %w", k, err)
 		}
-		kv.Offset += int64(len(v))
 
 		if _, err := f.Write(buf.Bytes()); err != nil {
-			log.Error(err)
+			return nil, err
 		}
+		kv.countWritten(len(v), stored)
+
+		memIndex[k] = Index{kv.offset}
+		kv.offset += int64(buf.Len())
+		kv.countDiskWrite(buf.Len())
+	}
+
+	if err := kv.syncData(f, kv.offset-dataOffset); err != nil {
+		return nil, err
 	}
 
-	kv.syncMemIndexToDisk()
-	kv.MemTable = map[string]string{}
+	return memIndex, blobs.close()
 }
 
-func (kv *KV) syncMemIndexToDisk() {
-	f, err := os.OpenFile(kv.indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// truncateFlush cuts the data and index files back to the sizes they had
+// before a flush that failed, so that the records it appended are not left
+// ahead of those of the next one. Should that fail too, the next flush
+// appends after them; they are never referenced.
+func (kv *KV) truncateFlush(dataOffset, indexOffset int64) {
+	if kv.offset != dataOffset {
+		kv.offset = cutFile(kv.dbPath, dataOffset)
+	}
+	if kv.indexOffset != indexOffset {
+		kv.indexOffset = cutFile(kv.indexPath, indexOffset)
+	}
+
+	if kv.mmap {
+		kv.files.retire(kv.dbPath)
+	}
+}
+
+// cutFile truncates the file at path to size and returns the size it has.
+func cutFile(path string, size int64) int64 {
+	err := os.Truncate(path, size)
+	if err == nil || os.IsNotExist(err) {
+		return size
+	}
+
+	log.Error("Error: ", err)
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Size()
+	}
+
+	return size
+}
+
+// syncData syncs f, the data file, to which n bytes were appended since it
+// was last synced, and reports the sync to the listener.
+func (kv *KV) syncData(f *os.File, n int64) error {
+	start := time.Now()
+	err := f.Sync()
+
+	info := SyncInfo{Path: f.Name(), Bytes: n, Duration: time.Since(start), Err: err}
+	kv.events.push(func(l EventListener) { l.OnWALSync(info) })
+
+	return err
+}
 
+// appendValue encodes the record of key in format f, writing value to a blob
+// file first if it is large enough. It returns the number of value bytes
+// stored.
+func (kv *KV) appendValue(buf *bytes.Buffer, blobs *blobWriter, f fileFormat, key, value string) (int, error) {
+	if !kv.isBlobValue(f, value) {
+		return appendRecord(buf, f, kv.keys, key, value, kv.compression)
+	}
+
+	p, stored, err := blobs.write(key, value)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	defer f.Close()
 
-	for k, v := range kv.MemIndex {
-		buf := bytes.NewBuffer([]byte{})
+	return stored, appendPointerRecord(buf, f, kv.keys, key, p)
+}
 
-		if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(k)))); err != nil {
-			return
-		}
-		if err = binary.Write(buf, binary.BigEndian, v.Offset); err != nil {
-			return
+func (kv *KV) syncMemIndexToDisk(memIndex map[string]Index) error {
+	f, err := os.OpenFile(kv.indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if kv.indexOffset == 0 {
+		if _, err := f.Write(fileHeader(indexFileMagic, kv.indexFormat.keyID)); err != nil {
+			return err
 		}
+		kv.indexOffset = fileHeaderSize
+		kv.countDiskWrite(fileHeaderSize)
+	}
 
-		if _, err = buf.Write([]byte(k)); err != nil {
-			return
+	buf := bytes.NewBuffer([]byte{})
+
+	for k, v := range memIndex {
+		buf.Reset()
+
+		if err := appendIndexRecord(buf, kv.indexFormat, kv.keys, k, v.Offset); err != nil {
+			return fmt.Errorf("encoding the index entry of %q: %w", k, err)
 		}
 
 		if _, err := f.Write(buf.Bytes()); err != nil {
-			log.Error(err)
+			return err
 		}
+		kv.indexOffset += int64(buf.Len())
+		kv.countDiskWrite(buf.Len())
+	}
+
+	return f.Sync()
+}
+
+// CompactData rewrites the data and index files keeping only the latest
+// value of every live key. Writes keep going to the memtable meanwhile; it is
+// not flushed until the compaction is done. Values stored in blob files are
+// not copied, only the pointers to them; blob files that no live key points
+// to any more are removed.
+func (kv *KV) CompactData() error {
+	return kv.CompactDataContext(context.Background())
+}
+
+// CompactDataContext is CompactData, abandoning the compaction with the error
+// of ctx once it is done. The files are left as they were before it started.
+func (kv *KV) CompactDataContext(ctx context.Context) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	// In-memory stores have nothing to compact.
+	if kv.inMemory {
+		return nil
 	}
 
-	kv.MemIndex = map[string]Index{}
+	return kv.compactData(ctx)
 }
 
-func (kv *KV) CompactData() {
-	if kv.isCompacting.Value() {
-		return
+// compactData returns the error the compaction ran into, if any. It is
+// abandoned, leaving the store as it was, once ctx is done.
+func (kv *KV) compactData(ctx context.Context) error {
+	if !kv.isCompacting.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer kv.isCompacting.Set(false)
+
+	kv.flushLock.Lock()
+	defer kv.flushLock.Unlock()
+
+	start := time.Now()
+	info := CompactionInfo{InputBytes: kv.offset, StartTime: start}
+	kv.notifyCompactionBegin(info)
+	defer func() {
+		info.Duration = time.Since(start)
+		kv.notifyCompactionEnd(info)
+	}()
+
+	fail := func(err error) {
+		info.Err = err
+		kv.backgroundError("compaction", err)
 	}
 
-	kv.isCompacting.Set(true)
+	kv.lock.RLock()
+	current := make(map[string]Index, len(kv.index))
+	for k, v := range kv.index {
+		current[k] = v
+	}
+	h, err := kv.files.acquire(kv.dbPath)
+	kv.lock.RUnlock()
 
-	indexFile, err := os.OpenFile(
-		fmt.Sprintf("compacted_%s", filepath.Base(kv.indexPath)), os.O_CREATE|os.O_WRONLY, 0644,
-	)
+	if err != nil {
+		fail(err)
+		return info.Err
+	}
+	defer kv.files.release(h)
 
+	w, err := kv.newRewriter()
 	if err != nil {
-		panic(err)
+		fail(err)
+		return info.Err
 	}
-	defer indexFile.Close()
+	defer w.close()
+
+	for k, indexVal := range current {
+		if err := ctx.Err(); err != nil {
+			info.Err = err
+			return err
+		}
 
-	dbFile, err := os.OpenFile(
-		fmt.Sprintf("compacted_%s", filepath.Base(kv.dbPath)), os.O_CREATE|os.O_WRONLY, 0644,
-	)
+		if err := w.copyRecord(h, k, indexVal.Offset); err != nil {
+			fail(err)
+			return info.Err
+		}
+	}
+
+	removed, err := w.install()
 	if err != nil {
-		panic(err)
+		fail(err)
+		return info.Err
 	}
-	defer dbFile.Close()
 
-	var index map[string]Index
-	index = make(map[string]Index)
-	var offset int64
+	kv.trackOperation(&kv.compactions, start)
 
-	for k := range kv.Index {
-		v, ok := kv.Get(k)
-		if ok {
-			fmt.Println(k, "=", v)
+	info.OutputBytes = w.offset
+	info.Keys = int64(len(w.index))
+	info.BlobFilesRemoved = removed
 
-			// SAVE DB
-			index[k] = Index{offset}
-			buf := bytes.NewBuffer([]byte{})
+	return info.Err
+}
 
-			offset += 16
-			if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(k)))); err != nil {
-				return
-			}
-			if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(v)))); err != nil {
-				return
-			}
+// installCompacted renames the compacted versions of the given files over
+// the current ones. The caller must hold lock and flushLock.
+func (kv *KV) installCompacted(paths ...string) {
+	for _, path := range paths {
+		if err := os.Rename(path+compactedSuffix, path); err != nil {
+			// The manifest names the compacted file, so the rename is
+			// retried when the store is next opened.
+			panic(err)
+		}
+	}
+	if err := syncDir(filepath.Dir(kv.dbPath)); err != nil {
+		log.Error("Error: ", err)
+	}
 
-			if _, err = buf.Write([]byte(k)); err != nil {
-				return
-			}
-			offset += int64(len(k))
+	// Readers that still hold the handle of the replaced file keep reading
+	// from it; it is closed once the last of them releases it.
+	kv.files.retire(kv.dbPath)
+}
 
-			if _, err = buf.Write([]byte(v)); err != nil {
-				return
-			}
-			offset += int64(len(v))
+func (kv *KV) countWritten(raw, stored int) {
+	atomic.AddInt64(&kv.rawBytes, int64(raw))
+	atomic.AddInt64(&kv.storedBytes, int64(stored))
+}
 
-			if _, err := dbFile.Write(buf.Bytes()); err != nil {
-				log.Error(err)
-			}
+// CompressionStats returns how well the values written since the store was
+// opened compressed.
+func (kv *KV) CompressionStats() CompressionStats {
+	return CompressionStats{
+		Compression: kv.compression,
+		RawBytes:    atomic.LoadInt64(&kv.rawBytes),
+		StoredBytes: atomic.LoadInt64(&kv.storedBytes),
+	}
+}
 
-			// SAVE INDEX
-			buf.Reset()
+// CacheStats returns hit and miss counters and the current occupancy of the
+// value cache. The zero value is returned when the cache is disabled.
+func (kv *KV) CacheStats() CacheStats {
+	if kv.cache == nil {
+		return CacheStats{}
+	}
 
-			if err = binary.Write(buf, binary.BigEndian, int64(len([]byte(k)))); err != nil {
-				return
-			}
-			if err = binary.Write(buf, binary.BigEndian, index[k].Offset); err != nil {
-				return
-			}
+	return kv.cache.Stats()
+}
 
-			if _, err = buf.Write([]byte(k)); err != nil {
-				return
-			}
+// Items returns a point-in-time copy of every live key and its value, or nil
+// if a record cannot be read. Snapshot returns the error instead.
+func (kv *KV) Items() map[string]string {
+	items, err := kv.items(context.Background())
+	if err != nil {
+		log.Error("Error: ", err)
+	}
+	return items
+}
 
-			if _, err := indexFile.Write(buf.Bytes()); err != nil {
-				log.Error(err)
-			}
+// items is Items, giving up with the error of ctx once it is done.
+func (kv *KV) items(ctx context.Context) (map[string]string, error) {
+	kv.blobLock.RLock()
+	defer kv.blobLock.RUnlock()
+
+	// Writers hold lock for reading while they insert into the memtable, so
+	// the write lock gives a consistent view of the memtables and the index.
+	kv.lock.Lock()
+	index := make(map[string]Index, len(kv.index))
+	for k, v := range kv.index {
+		index[k] = v
+	}
+	memTables := make([]map[string]string, 0, len(kv.immutables)+1)
+	for _, mt := range kv.immutables {
+		memTables = append(memTables, mt.items())
+	}
+	memTables = append(memTables, kv.active.items())
+	// In-memory stores have no data file to read.
+	var h *readHandle
+	var err error
+	if len(index) > 0 {
+		h, err = kv.files.acquire(kv.dbPath)
+	}
+	kv.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		defer kv.files.release(h)
+	}
+
+	items := make(map[string]string, len(index))
+
+	for k, indexVal := range index {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// A record that cannot be read fails the whole copy rather than
+		// leaving its key out of it.
+		v, err := kv.readValue(h, k, indexVal.Offset)
+		if err != nil {
+			return nil, err
 		}
+		items[k] = v
 	}
-	os.Remove(kv.dbPath)
-	os.Remove(kv.indexPath)
 
-	os.Rename(fmt.Sprintf("compacted_%s", filepath.Base(kv.dbPath)), kv.dbPath)
-	os.Rename(fmt.Sprintf("compacted_%s", filepath.Base(kv.indexPath)), kv.indexPath)
+	for _, mt := range memTables {
+		for k, v := range mt {
+			items[k] = v
+		}
+	}
 
-	kv.Lock.Lock()
-	kv.Index = index
-	kv.Lock.Unlock()
+	for k, v := range items {
+		if v == "__KVGO_TOMBSTONE__" {
+			delete(items, k)
+		}
+	}
 
-	kv.isCompacting.Set(false)
+	return items, nil
+}
+
+// Reset discards everything in the store and replaces it with items.
+func (kv *KV) Reset(items map[string]string) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+
+	if kv.inMemory {
+		mt := newMemTable()
+		for k, v := range items {
+			mt.set(k, v)
+		}
+
+		kv.lock.Lock()
+		kv.active = mt
+		kv.lock.Unlock()
+		return nil
+	}
+
+	return kv.reset(items)
+}
+
+func (kv *KV) reset(items map[string]string) error {
+	kv.flushLock.Lock()
+	defer kv.flushLock.Unlock()
+
+	// The items are written straight to new files that replace the current
+	// ones the way a compaction does, so a crash or an error leaves either
+	// the old contents or the new ones.
+	w, err := kv.newRewriter()
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	for k, v := range items {
+		if err := w.writeValue(k, v); err != nil {
+			return err
+		}
+	}
+
+	w.dropMemTables = true
+	_, err = w.install()
+	return err
 }
 
-func (kv *KV) Close() {
+// Close flushes the memtables and closes the store. It returns the error of
+// the flush, in which case the writes that were not flushed are lost.
+func (kv *KV) Close() error {
 	if log.GetLevel() == log.DebugLevel {
 		defer TimeTrack(time.Now(), "Close")
 	}
 
-	kv.SyncToDisk()
+	if !kv.isClosed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	if kv.inMemory {
+		return kv.closeMemory()
+	}
+
+	if kv.readOnly {
+		kv.releasePinned()
+		kv.files.close()
+		kv.dirLock.unlock()
+		kv.watchers.closeAll()
+		kv.events.close()
+		return nil
+	}
+
+	err := kv.SyncToDisk()
+
+	close(kv.flushC)
+	<-kv.flusherDone
+
+	kv.files.close()
+	kv.manifest.close()
+	kv.dirLock.unlock()
+	kv.watchers.closeAll()
+
+	// Delivering the events queued so far means a listener has seen
+	// everything that happened by the time Close returns.
+	kv.events.close()
+
+	return err
 }
 
 func TimeTrack(start time.Time, name string) {
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -20,10 +21,11 @@ func TestBasic(t *testing.T) {
 	tmpDir, _ := ioutil.TempDir("", "benchmarkStore")
 	defer os.RemoveAll(tmpDir)
 
-	dbPath := filepath.Join(".", "data.db")
-	indexPath := filepath.Join(".", "indexes.idx")
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
 
 	store := NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
 	N := 10000
 
 	for i := 0; i < N; i++ {
@@ -68,8 +70,8 @@ func TestBasicParallel(t *testing.T) {
 	tmpDir, _ := ioutil.TempDir("", "benchmarkStore")
 	defer os.RemoveAll(tmpDir)
 
-	dbPath := filepath.Join(".", "data.db")
-	indexPath := filepath.Join(".", "indexes.idx")
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
 
 	store := NewKV(dbPath, indexPath, 500, 10)
 	N := 10000
@@ -151,10 +153,11 @@ func TestCompactionBasic(t *testing.T) {
 	var deletedKeys []string
 	deletedKeys = make([]string, 10)
 
-	dbPath := filepath.Join(".", "data.db")
-	indexPath := filepath.Join(".", "indexes.idx")
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
 
 	store := NewKV(dbPath, indexPath, 4, 10)
+	defer store.Close()
 	N := 100
 
 	for i := 0; i < N; i++ {
@@ -244,3 +247,228 @@ func getFileSize(filePath string) int64 {
 	st, _ := f.Stat()
 	return st.Size()
 }
+
+func TestItemsAndReset(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 10, 10)
+	N := 100
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	for i := 0; i < N; i += 2 {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.Set("key_1", "value_1_1")
+
+	items := store.Items()
+	assetEqual(t, "items", N/2, len(items))
+	assetEqual(t, "key_1", "value_1_1", items["key_1"])
+
+	store.Reset(map[string]string{"key_0": "value_0", "other": "value"})
+
+	for i := 1; i < N; i++ {
+		_, ok := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), false, ok)
+	}
+
+	value, _ := store.Get("key_0")
+	assetEqual(t, "key_0", "value_0", value)
+	assetEqual(t, "items", 2, len(store.Items()))
+	store.Close()
+
+	store = NewKV(dbPath, indexPath, 10, 10)
+	defer store.Close()
+
+	value, _ = store.Get("other")
+	assetEqual(t, "other", "value", value)
+	assetEqual(t, "items", 2, len(store.Items()))
+}
+
+func TestCloseFlushError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	store.Set("flushed", "value")
+	store.SyncToDisk()
+
+	// A directory in place of the data file makes the flush on Close fail.
+	os.Rename(dbPath, dbPath+".moved")
+	os.Mkdir(dbPath, 0755)
+
+	store.Set("key", "value")
+	if err := store.Close(); err == nil {
+		t.Errorf("Expected Close to fail\n")
+	}
+	assetEqual(t, "second close", nil, store.Close())
+}
+
+func TestResetError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 10, 10)
+	defer store.Close()
+
+	store.Set("key", "value")
+
+	// A directory in the way of the new data file makes the reset fail.
+	os.Mkdir(dbPath+compactedSuffix, 0755)
+
+	if err := store.Reset(map[string]string{"other": "value"}); err == nil {
+		t.Errorf("Expected Reset to fail\n")
+	}
+
+	value, _ := store.Get("key")
+	assetEqual(t, "key", "value", value)
+
+	_, ok := store.Get("other")
+	assetEqual(t, "other", false, ok)
+}
+
+func TestConcurrentSetDuringFlush(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 50, 10, WithMemTableSize(512), WithMaxImmutableMemTables(1))
+	defer store.Close()
+	N := 2000
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < N; i += 4 {
+				key := fmt.Sprintf("key_%d", i)
+				store.Set(key, fmt.Sprintf("value_%d", i))
+
+				value, _ := store.Get(key)
+				assetEqual(t, key, fmt.Sprintf("value_%d", i), value)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+}
+
+func TestBackgroundFlush(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithMemTableSize(256), WithMaxImmutableMemTables(2))
+	N := 1000
+
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+
+	store.lock.RLock()
+	active := store.active.bytes()
+	store.lock.RUnlock()
+
+	if active > 256+32 {
+		t.Errorf("Expected active memtable to stay within its budget. Got `%v` bytes\n", active)
+	}
+
+	store.flushImmutables()
+
+	if getFileSize(dbPath) == 0 {
+		t.Errorf("Expected full memtables to be flushed in the background\n")
+	}
+
+	store.Close()
+
+	store = NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	for i := 0; i < N; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+}
+
+func TestFlushError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10, WithMemTableSize(256), WithMaxImmutableMemTables(1))
+
+	store.Set("flushed", "value")
+	assetEqual(t, "first flush", nil, store.SyncToDisk())
+	size := getFileSize(dbPath)
+
+	// A directory in place of the data file makes every flush fail.
+	os.Rename(dbPath, dbPath+".moved")
+	os.Mkdir(dbPath, 0755)
+
+	var err error
+	written := 0
+	for i := 0; i < 1000 && err == nil; i++ {
+		if err = store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i)); err == nil {
+			written++
+		}
+	}
+	if err == nil {
+		t.Errorf("Expected writes to fail once the flush queue is full\n")
+	}
+	if store.SyncToDisk() == nil {
+		t.Errorf("Expected SyncToDisk to fail\n")
+	}
+
+	for i := 0; i < written; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+
+	os.Remove(dbPath)
+	os.Rename(dbPath+".moved", dbPath)
+	assetEqual(t, "data file untouched", size, getFileSize(dbPath))
+
+	assetEqual(t, "flush after recovery", nil, store.SyncToDisk())
+	assetEqual(t, "write after recovery", nil, store.Set("after", "value"))
+	assetEqual(t, "close", nil, store.Close())
+
+	store = NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	for i := 0; i < written; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+	value, _ := store.Get("flushed")
+	assetEqual(t, "flushed", "value", value)
+	value, _ = store.Get("after")
+	assetEqual(t, "after", "value", value)
+}
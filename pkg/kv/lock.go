@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// A process opening a store for writing holds an exclusive lock on the LOCK
+// file in its directory, so a second writer fails with ErrLocked instead of
+// interleaving its records with the first one's. Read-only opens hold a
+// shared lock on LOCK.readers instead, which lets them run next to the
+// writer while still keeping out maintenance that needs the directory to
+// itself, such as Repair, which takes both locks exclusively.
+const (
+	lockFileName        = "LOCK"
+	readersLockFileName = "LOCK.readers"
+)
+
+var ErrLocked = errors.New("kv: database is locked by another process")
+
+type fileLock struct {
+	files []*os.File
+}
+
+// lockFile takes an exclusive or shared lock on the file at path, creating
+// it if needed. It returns ErrLocked if a conflicting lock is held.
+func lockFile(path string, exclusive bool) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flock(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileLock{files: []*os.File{f}}, nil
+}
+
+// lockDir takes the lock of the store kept in dir: the exclusive LOCK for
+// writers, the shared LOCK.readers otherwise.
+func lockDir(dir string, readOnly bool) (*fileLock, error) {
+	if readOnly {
+		return lockFile(filepath.Join(dir, readersLockFileName), false)
+	}
+
+	return lockFile(filepath.Join(dir, lockFileName), true)
+}
+
+// lockDirExclusive takes both locks of the store kept in dir exclusively,
+// for maintenance that must not run next to a writer or any reader.
+func lockDirExclusive(dir string) (*fileLock, error) {
+	l, err := lockFile(filepath.Join(dir, lockFileName), true)
+	if err != nil {
+		return nil, err
+	}
+
+	readers, err := lockFile(filepath.Join(dir, readersLockFileName), true)
+	if err != nil {
+		l.unlock()
+		return nil, err
+	}
+	l.files = append(l.files, readers.files...)
+
+	return l, nil
+}
+
+func (l *fileLock) unlock() {
+	// Closing the files releases the locks.
+	for _, f := range l.files {
+		f.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockExclusive(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store, err := OpenKV(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+
+	_, err = OpenKV(dbPath, indexPath)
+	assetEqual(t, "second open", ErrLocked, err)
+
+	store.Close()
+
+	store, err = OpenKV(dbPath, indexPath)
+	assetEqual(t, "reopen", nil, err)
+	store.Close()
+}
+
+func TestLockReadOnly(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	_, err := OpenKV(dbPath, indexPath, WithReadOnly())
+	if err == nil {
+		t.Errorf("Expected an error opening a missing store read-only\n")
+	}
+	assetEqual(t, "created", false, fileExists(dbPath))
+
+	writer, err := OpenKV(dbPath, indexPath)
+	assetEqual(t, "err", nil, err)
+	defer writer.Close()
+
+	N := 100
+	for i := 0; i < N; i++ {
+		writer.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	writer.SyncToDisk()
+
+	readers := make([]*KV, 2)
+	for i := range readers {
+		readers[i], err = OpenKV(dbPath, indexPath, WithReadOnly())
+		assetEqual(t, "read-only open", nil, err)
+	}
+
+	size := fileSize(dbPath)
+
+	for _, reader := range readers {
+		for i := 0; i < N; i++ {
+			value, _ := reader.Get(fmt.Sprintf("key_%d", i))
+			assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+		}
+
+		assetEqual(t, "set", ErrReadOnly, reader.Set("key_0", "changed"))
+		reader.Close()
+	}
+
+	assetEqual(t, "data size", size, fileSize(dbPath))
+
+	value, _ := writer.Get("key_0")
+	assetEqual(t, "key_0", "value_0", value)
+}
@@ -0,0 +1,375 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The manifest is the source of truth for which files make up the store.
+// It is a log of versions, each one describing the complete set of live
+// files and how many bytes of the data and index files were committed. Every
+// flush and compaction appends a version once the files it wrote are synced,
+// so a crash at any point leaves the store as of the last appended version:
+// bytes past the committed sizes are truncated and files it doesn't name are
+// removed when the store is opened.
+//
+// The manifest starts with the usual file header, followed by records laid
+// out as:
+//
+//	crc32 (4) | length (4) | version encoded as JSON
+//
+// A compaction writes the new data and index files next to the current ones
+// with compactedSuffix, appends a version naming them, and only then renames
+// them over the current files. A version that still names compacted files is
+// rolled forward by redoing the renames on open.
+const (
+	manifestMagic            = "KVGM"
+	manifestSuffix           = ".MANIFEST"
+	compactedSuffix          = ".compacted"
+	manifestRecordHeaderSize = 8
+	maxManifestRecords       = 1000
+	manifestRewriteSuffix    = ".tmp"
+)
+
+var ErrNeedsRecovery = errors.New("kv: database has an interrupted compaction; open it for writing to recover it")
+
+type manifestVersion struct {
+	Version   uint64   `json:"version"`
+	DataFile  string   `json:"data_file"`
+	DataSize  int64    `json:"data_size"`
+	IndexFile string   `json:"index_file"`
+	IndexSize int64    `json:"index_size"`
+	BlobFiles []uint64 `json:"blob_files"`
+}
+
+type manifest struct {
+	path    string
+	f       *os.File
+	records int
+}
+
+// readManifest returns the last complete version recorded in the manifest at
+// path. A torn record at the end, left by a crash while it was appended, is
+// ignored.
+func readManifest(path string) (manifestVersion, error) {
+	var v manifestVersion
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return v, err
+	}
+
+	if len(data) < fileHeaderSize || string(data[:4]) != manifestMagic {
+		return v, ErrCorruptRecord
+	}
+
+	found := false
+	for r := data[fileHeaderSize:]; len(r) >= manifestRecordHeaderSize; {
+		length := int(binary.BigEndian.Uint32(r[4:8]))
+		if len(r) < manifestRecordHeaderSize+length {
+			break
+		}
+
+		payload := r[manifestRecordHeaderSize : manifestRecordHeaderSize+length]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(r[:4]) {
+			break
+		}
+
+		var next manifestVersion
+		if err := json.Unmarshal(payload, &next); err != nil {
+			break
+		}
+		v = next
+		found = true
+
+		r = r[manifestRecordHeaderSize+length:]
+	}
+
+	if !found {
+		return v, ErrCorruptRecord
+	}
+
+	return v, nil
+}
+
+// createManifest atomically replaces the manifest at path with one holding
+// only v and opens it for appending.
+func createManifest(path string, v manifestVersion) (*manifest, error) {
+	tmp := path + manifestRewriteSuffix
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(fileHeader(manifestMagic, 0))
+	if err := appendManifestRecord(buf, v); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	if f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+
+	return &manifest{path: path, f: f, records: 1}, nil
+}
+
+// append durably records v as the current version. The manifest is
+// rewritten from scratch once it holds too many versions.
+func (m *manifest) append(v manifestVersion) error {
+	if m.records >= maxManifestRecords {
+		m.f.Close()
+
+		fresh, err := createManifest(m.path, v)
+		if err != nil {
+			return err
+		}
+		*m = *fresh
+
+		return nil
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := appendManifestRecord(buf, v); err != nil {
+		return err
+	}
+
+	if _, err := m.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := m.f.Sync(); err != nil {
+		return err
+	}
+	m.records++
+
+	return nil
+}
+
+func (m *manifest) close() {
+	m.f.Close()
+}
+
+func appendManifestRecord(buf *bytes.Buffer, v manifestVersion) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(payload))
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// openManifest brings the files of the store back to the last version
+// recorded in its manifest, or records the files found on disk as the first
+// version if there is no manifest yet. It runs before anything else is read.
+func (kv *KV) openManifest() error {
+	path := kv.dbPath + manifestSuffix
+	dataName := filepath.Base(kv.dbPath)
+	indexName := filepath.Base(kv.indexPath)
+
+	v, err := kv.loadManifest()
+	if err != nil && err != ErrNeedsRecovery {
+		return err
+	}
+
+	// Roll an interrupted compaction forward.
+	if v.DataFile != dataName {
+		if err := kv.rollForward(filepath.Join(filepath.Dir(kv.dbPath), v.DataFile), kv.dbPath); err != nil {
+			return err
+		}
+	}
+	if v.IndexFile != indexName {
+		if err := kv.rollForward(filepath.Join(filepath.Dir(kv.indexPath), v.IndexFile), kv.indexPath); err != nil {
+			return err
+		}
+	}
+
+	v.DataFile, v.IndexFile = dataName, indexName
+
+	truncateTo(kv.dbPath, v.DataSize)
+	truncateTo(kv.indexPath, v.IndexSize)
+
+	kv.blobFileList = v.BlobFiles
+	kv.version = v.Version
+	kv.removeOrphans()
+
+	// The data and index sizes are only known once the files are loaded; the
+	// first version is recorded by the commit at the end of NewKV.
+	kv.manifest, err = createManifest(path, v)
+
+	return err
+}
+
+// loadManifest returns the current version of the store. Stores created
+// before the manifest was introduced are described by the files on disk.
+// ErrNeedsRecovery is returned along with the version if it names the files
+// of a compaction that has not been renamed into place yet.
+func (kv *KV) loadManifest() (manifestVersion, error) {
+	v, err := readManifest(kv.dbPath + manifestSuffix)
+
+	switch {
+	case os.IsNotExist(err):
+		return manifestVersion{
+			DataFile:  filepath.Base(kv.dbPath),
+			DataSize:  -1,
+			IndexFile: filepath.Base(kv.indexPath),
+			IndexSize: -1,
+			BlobFiles: kv.findBlobFiles(),
+		}, nil
+	case err != nil:
+		return v, err
+	}
+
+	if v.DataFile != filepath.Base(kv.dbPath) || v.IndexFile != filepath.Base(kv.indexPath) {
+		return v, ErrNeedsRecovery
+	}
+
+	return v, nil
+}
+
+func (kv *KV) rollForward(from, to string) error {
+	if !fileExists(from) {
+		// Already renamed before the crash.
+		return nil
+	}
+
+	log.Warn("Completing interrupted compaction: renaming ", from, " to ", to)
+
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(to)); err != nil {
+		log.Error("Error: ", err)
+	}
+
+	return nil
+}
+
+// truncateTo drops the bytes of path past size, which were written by a
+// flush that did not get to commit. A negative size leaves the file as is.
+func truncateTo(path string, size int64) {
+	if size < 0 {
+		return
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		if size > 0 {
+			log.Error("Error: ", err)
+		}
+		return
+	}
+
+	switch {
+	case st.Size() > size:
+		log.Warn("Truncating ", path, " from ", st.Size(), " to ", size, " committed bytes")
+
+		if err := os.Truncate(path, size); err != nil {
+			log.Error("Error: ", err)
+		}
+	case st.Size() < size:
+		log.Error(path, " is ", st.Size(), " bytes but ", size, " were committed")
+	}
+}
+
+// removeOrphans removes the files left behind by flushes and compactions
+// that did not get to commit, and by compactions that committed but did not
+// get to remove the files they replaced.
+func (kv *KV) removeOrphans() {
+	orphans := []string{
+		kv.dbPath + compactedSuffix,
+		kv.indexPath + compactedSuffix,
+		kv.dbPath + manifestSuffix + manifestRewriteSuffix,
+	}
+
+	live := make(map[uint64]bool, len(kv.blobFileList))
+	for _, file := range kv.blobFileList {
+		live[file] = true
+	}
+	for _, file := range kv.findBlobFiles() {
+		if !live[file] {
+			orphans = append(orphans, kv.blobPath(file))
+		}
+	}
+
+	for _, path := range orphans {
+		if !fileExists(path) {
+			continue
+		}
+
+		log.Info("Removing orphaned file ", path)
+
+		if err := os.Remove(path); err != nil {
+			log.Error("Error: ", err)
+		}
+	}
+}
+
+// commit appends the current state of the files to the manifest. The caller
+// must hold flushLock, and everything written must have been synced. Nothing
+// written since the last commit survives a restart if it returns an error.
+func (kv *KV) commit() error {
+	return kv.commitAs(filepath.Base(kv.dbPath), filepath.Base(kv.indexPath), kv.offset, kv.indexOffset)
+}
+
+func (kv *KV) commitAs(dataFile, indexFile string, dataSize, indexSize int64) error {
+	v := manifestVersion{
+		Version:   kv.version + 1,
+		DataFile:  dataFile,
+		DataSize:  dataSize,
+		IndexFile: indexFile,
+		IndexSize: indexSize,
+		BlobFiles: kv.blobFileList,
+	}
+
+	if err := kv.manifest.append(v); err != nil {
+		return err
+	}
+	kv.version = v.Version
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fillStore(dbPath, indexPath string, n int) {
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	for i := 0; i < n; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.Close()
+}
+
+func checkStore(t *testing.T, dbPath, indexPath string, n int) {
+	t.Helper()
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	for i := 0; i < n; i++ {
+		value, _ := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+	}
+}
+
+func TestManifestInterruptedCompaction(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+	N := 100
+
+	fillStore(dbPath, indexPath, N)
+
+	// Leave the store as a compaction that committed but crashed before
+	// renaming its files would: the data file renamed, the index file not.
+	os.Rename(indexPath, indexPath+compactedSuffix)
+	ioutil.WriteFile(indexPath, []byte("stale index"), 0644)
+
+	v, err := readManifest(dbPath + manifestSuffix)
+	assetEqual(t, "err", nil, err)
+
+	v.IndexFile = filepath.Base(indexPath + compactedSuffix)
+	m, _ := createManifest(dbPath+manifestSuffix, v)
+	m.close()
+
+	checkStore(t, dbPath, indexPath, N)
+	assetEqual(t, "compacted index", false, fileExists(indexPath+compactedSuffix))
+}
+
+func TestManifestUncommittedWrites(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+	N := 100
+
+	fillStore(dbPath, indexPath, N)
+
+	dataSize := fileSize(dbPath)
+	indexSize := fileSize(indexPath)
+
+	// Bytes written after the last commit, like a torn flush, along with
+	// files written by a compaction that did not get to commit.
+	for _, path := range []string{dbPath, indexPath, dbPath + manifestSuffix} {
+		f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 42, 1, 2, 3})
+		f.Close()
+	}
+	orphans := []string{dbPath + compactedSuffix, indexPath + compactedSuffix, dbPath + ".000042.blob"}
+	for _, path := range orphans {
+		ioutil.WriteFile(path, []byte("orphan"), 0644)
+	}
+
+	checkStore(t, dbPath, indexPath, N)
+
+	assetEqual(t, "data size", dataSize, fileSize(dbPath))
+	assetEqual(t, "index size", indexSize, fileSize(indexPath))
+	for _, path := range orphans {
+		assetEqual(t, path, false, fileExists(path))
+	}
+}
+
+func TestManifestCompaction(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+	N := 100
+
+	fillStore(dbPath, indexPath, N)
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+	store.CompactData()
+	store.Close()
+
+	v, err := readManifest(dbPath + manifestSuffix)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "data file", filepath.Base(dbPath), v.DataFile)
+	assetEqual(t, "data size", fileSize(dbPath), v.DataSize)
+	assetEqual(t, "index size", fileSize(indexPath), v.IndexSize)
+
+	checkStore(t, dbPath, indexPath, N)
+}
+
+func TestManifestWithoutManifest(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+	N := 100
+
+	fillStore(dbPath, indexPath, N)
+	os.Remove(dbPath + manifestSuffix)
+
+	checkStore(t, dbPath, indexPath, N)
+	assetEqual(t, "manifest", true, fileExists(dbPath+manifestSuffix))
+}
+
+func TestManifestRewrite(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "data.db"+manifestSuffix)
+
+	m, err := createManifest(path, manifestVersion{Version: 1})
+	assetEqual(t, "err", nil, err)
+	defer func() { m.close() }()
+
+	for i := 2; i <= maxManifestRecords+10; i++ {
+		m.append(manifestVersion{Version: uint64(i)})
+	}
+
+	assetEqual(t, "records", 10, m.records)
+
+	v, err := readManifest(path)
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "version", uint64(maxManifestRecords+10), v.Version)
+}
+
+func TestManifestCommitError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	store := NewKV(dbPath, indexPath, 1000, 10)
+	store.Set("flushed", "value")
+	assetEqual(t, "first flush", nil, store.SyncToDisk())
+	size := getFileSize(dbPath)
+
+	// A closed manifest makes every commit fail.
+	store.manifest.f.Close()
+
+	store.Set("key", "value")
+	if store.SyncToDisk() == nil {
+		t.Errorf("Expected SyncToDisk to fail\n")
+	}
+	assetEqual(t, "data file truncated", size, getFileSize(dbPath))
+
+	value, _ := store.Get("key")
+	assetEqual(t, "kept in memory", "value", value)
+
+	store.manifest.f, _ = os.OpenFile(dbPath+manifestSuffix, os.O_APPEND|os.O_WRONLY, 0644)
+	assetEqual(t, "flush after recovery", nil, store.SyncToDisk())
+	assetEqual(t, "close", nil, store.Close())
+
+	store = NewKV(dbPath, indexPath, 1000, 10)
+	defer store.Close()
+
+	value, _ = store.Get("key")
+	assetEqual(t, "key", "value", value)
+	value, _ = store.Get("flushed")
+	assetEqual(t, "flushed", "value", value)
+}
+
+func TestManifestOpenError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	fillStore(dbPath, indexPath, 10)
+	ioutil.WriteFile(dbPath+manifestSuffix, []byte("not a manifest"), 0644)
+
+	_, err := OpenKV(dbPath, indexPath)
+	assetEqual(t, "open", ErrCorruptRecord, err)
+
+	// The store is not left locked.
+	os.Remove(dbPath + manifestSuffix)
+	checkStore(t, dbPath, indexPath, 10)
+}
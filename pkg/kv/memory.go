@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInMemory is returned by operations that need files, such as backups,
+// when called on an in-memory store.
+var ErrInMemory = errors.New("kv: not supported by in-memory stores")
+
+// OpenMemoryKV opens a store that keeps everything in memory and never
+// touches disk, for caches and tests. Everything but backups works as for a
+// store on disk; CompactData and CompactBlobs do nothing. Its content is lost
+// when it is closed unless WithSnapshotFile is given, in which case it is
+// written to a snapshot file that the next OpenMemoryKV loads it from.
+func OpenMemoryKV(opts ...Option) (*KV, error) {
+	kv := newKV("", "", opts...)
+	kv.inMemory = true
+
+	if kv.snapshotPath != "" {
+		if err := kv.loadSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+
+	// There is no flusher: the memtable is never rotated.
+	close(kv.flusherDone)
+	kv.events.start()
+
+	if kv.snapshotPath != "" && kv.snapshotInterval > 0 {
+		kv.snapshotStop = make(chan struct{})
+		kv.snapshotDone = make(chan struct{})
+		go kv.snapshotter()
+	}
+
+	return kv, nil
+}
+
+// NewMemoryKV is OpenMemoryKV for callers that don't use snapshot files. It
+// panics if the store cannot be opened.
+func NewMemoryKV(opts ...Option) *KV {
+	kv, err := OpenMemoryKV(opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return kv
+}
+
+// loadSnapshot fills the memtable from the snapshot file, if there is one.
+func (kv *KV) loadSnapshot() error {
+	data, err := ioutil.ReadFile(kv.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if IsEncrypted(data) {
+		if kv.keys == nil {
+			return fmt.Errorf("snapshot %s is encrypted but no key ring is configured", kv.snapshotPath)
+		}
+		if data, err = kv.keys.Decrypt(data); err != nil {
+			return err
+		}
+	}
+
+	d := NewDumpReader(bytes.NewReader(data), BinaryDump)
+	for {
+		key, value, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", kv.snapshotPath, err)
+		}
+
+		kv.active.set(key, value)
+	}
+}
+
+// writeSnapshot writes the content of the store to the snapshot file as a
+// binary dump, encrypted with the key ring if there is one. The file is
+// replaced atomically, so a crash leaves either the old or the new snapshot.
+func (kv *KV) writeSnapshot() error {
+	if kv.snapshotPath == "" {
+		return nil
+	}
+
+	kv.snapshotLock.Lock()
+	defer kv.snapshotLock.Unlock()
+
+	buf := bytes.NewBuffer([]byte{})
+	if _, err := kv.Export(buf, BinaryDump); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if kv.keys != nil {
+		var err error
+		if data, err = kv.keys.Encrypt(data); err != nil {
+			return err
+		}
+	}
+
+	tmp := kv.snapshotPath + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, kv.snapshotPath); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(kv.snapshotPath))
+}
+
+func (kv *KV) snapshotter() {
+	defer close(kv.snapshotDone)
+
+	ticker := time.NewTicker(kv.snapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			if err := kv.writeSnapshot(); err != nil {
+				kv.backgroundError("snapshot", err)
+				continue
+			}
+			log.Debug("Wrote snapshot ", kv.snapshotPath, " in ", time.Since(start))
+		case <-kv.snapshotStop:
+			return
+		}
+	}
+}
+
+func (kv *KV) closeMemory() error {
+	if kv.snapshotStop != nil {
+		close(kv.snapshotStop)
+		<-kv.snapshotDone
+	}
+
+	var err error
+	if !kv.readOnly {
+		if err = kv.writeSnapshot(); err != nil {
+			kv.backgroundError("snapshot", err)
+		}
+	}
+
+	kv.watchers.closeAll()
+	kv.events.close()
+
+	return err
+}
@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryKV(t *testing.T) {
+	store := NewMemoryKV(WithMemTableSize(1 << 10))
+	defer store.Close()
+
+	N := 1000
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	for i := 0; i < N; i += 2 {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.SyncToDisk()
+	store.CompactData()
+
+	for i := 0; i < N; i++ {
+		value, ok := store.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d exists", i), i%2 == 1, ok)
+		if ok {
+			assetEqual(t, fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i), value)
+		}
+	}
+
+	stats := store.Stats()
+	assetEqual(t, "memtables", 1, stats.MemTables)
+	assetEqual(t, "memtable entries", int64(N/2), stats.MemTableEntries)
+	assetEqual(t, "live keys", int64(N/2), stats.LiveKeys)
+
+	_, err := store.Backup(ioutil.Discard)
+	assetEqual(t, "backup", ErrInMemory, err)
+}
+
+func TestMemoryKVEngine(t *testing.T) {
+	store := NewMemoryKV()
+	defer store.Close()
+
+	testEngine(t, "memory kv", store)
+}
+
+func TestMemoryKVSnapshotFile(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "snapshot")
+
+	store := NewMemoryKV(WithSnapshotFile(path, 0))
+	store.Set("a", "1")
+	store.Set("b", "2")
+	store.Delete("b")
+	store.Close()
+
+	store = NewMemoryKV(WithSnapshotFile(path, 0))
+	value, _ := store.Get("a")
+	assetEqual(t, "a", "1", value)
+	_, ok := store.Get("b")
+	assetEqual(t, "b", false, ok)
+	store.Close()
+
+	keys, _ := ParseKeyRing("1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	store = NewMemoryKV(WithSnapshotFile(path, 10*time.Millisecond), WithKeyRing(keys))
+	store.Set("c", "3")
+
+	// The snapshot is written in the background while the store is open.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot := NewMemoryKV(WithSnapshotFile(path, 0), WithKeyRing(keys), WithReadOnly())
+		_, ok := snapshot.Get("c")
+		snapshot.Close()
+
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the snapshot to be written\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	store.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	assetEqual(t, "encrypted", true, IsEncrypted(data))
+
+	_, err := OpenMemoryKV(WithSnapshotFile(path, 0))
+	if err == nil {
+		t.Errorf("Expected an error opening an encrypted snapshot without keys\n")
+	}
+}
@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const memTableShards = 32
+
+type memTableShard struct {
+	lock  sync.RWMutex
+	items map[string]string
+}
+
+// memTable holds writes that have not been flushed to disk yet. Keys are
+// spread over independently locked shards so concurrent writers only contend
+// when they hit the same shard. size is the number of key and value bytes it
+// holds and is what decides when it is flushed.
+type memTable struct {
+	shards  [memTableShards]*memTableShard
+	entries int64
+	size    int64
+}
+
+func newMemTable() *memTable {
+	mt := new(memTable)
+
+	for i := range mt.shards {
+		mt.shards[i] = &memTableShard{items: make(map[string]string)}
+	}
+
+	return mt
+}
+
+func (mt *memTable) shard(key string) *memTableShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return mt.shards[h.Sum32()%memTableShards]
+}
+
+func (mt *memTable) get(key string) (string, bool) {
+	s := mt.shard(key)
+
+	s.lock.RLock()
+	val, ok := s.items[key]
+	s.lock.RUnlock()
+
+	return val, ok
+}
+
+func (mt *memTable) set(key, value string) {
+	s := mt.shard(key)
+
+	s.lock.Lock()
+	old, exists := s.items[key]
+	s.items[key] = value
+	s.lock.Unlock()
+
+	if exists {
+		atomic.AddInt64(&mt.size, int64(len(value)-len(old)))
+		return
+	}
+
+	atomic.AddInt64(&mt.entries, 1)
+	atomic.AddInt64(&mt.size, int64(len(key)+len(value)))
+}
+
+// delete removes key from the memtable. Stores on disk write a tombstone
+// instead so that the deletion reaches the files; in-memory stores have no
+// files to reach.
+func (mt *memTable) delete(key string) {
+	s := mt.shard(key)
+
+	s.lock.Lock()
+	old, exists := s.items[key]
+	delete(s.items, key)
+	s.lock.Unlock()
+
+	if exists {
+		atomic.AddInt64(&mt.entries, -1)
+		atomic.AddInt64(&mt.size, -int64(len(key)+len(old)))
+	}
+}
+
+func (mt *memTable) len() int64 {
+	return atomic.LoadInt64(&mt.entries)
+}
+
+func (mt *memTable) bytes() int64 {
+	return atomic.LoadInt64(&mt.size)
+}
+
+// items returns a copy of every entry in the memtable, tombstones included.
+func (mt *memTable) items() map[string]string {
+	items := make(map[string]string, mt.len())
+
+	for _, s := range mt.shards {
+		s.lock.RLock()
+		for k, v := range s.items {
+			items[k] = v
+		}
+		s.lock.RUnlock()
+	}
+
+	return items
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemTableBasic(t *testing.T) {
+	mt := newMemTable()
+
+	mt.set("key_1", "value_1")
+	mt.set("key_2", "value_2")
+	assetEqual(t, "entries", int64(2), mt.len())
+	assetEqual(t, "bytes", int64(24), mt.bytes())
+
+	mt.set("key_1", "value_333")
+	assetEqual(t, "overwrite", int64(2), mt.len())
+	assetEqual(t, "overwrite", int64(26), mt.bytes())
+
+	value, ok := mt.get("key_1")
+	assetEqual(t, "get", true, ok)
+	assetEqual(t, "get", "value_333", value)
+
+	_, ok = mt.get("key_3")
+	assetEqual(t, "missing", false, ok)
+
+	items := mt.items()
+	assetEqual(t, "items", 2, len(items))
+	assetEqual(t, "items", "value_2", items["key_2"])
+}
+
+func TestMemTableConcurrentSet(t *testing.T) {
+	mt := newMemTable()
+	N := 1000
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				mt.set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", g))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assetEqual(t, "entries", int64(N), mt.len())
+	if mt.bytes() <= 0 {
+		t.Errorf("Expected memtable size to be positive. Got `%v`\n", mt.bytes())
+	}
+	assetEqual(t, "items", N, len(mt.items()))
+}
@@ -0,0 +1,130 @@
+package kv
+
+import "time"
+
+const (
+	DefaultMemTableSize          = 4 << 20
+	DefaultMaxImmutableMemTables = 4
+)
+
+// Option configures optional behaviour of a KV created by NewKV.
+type Option func(*KV)
+
+// WithCacheSize sets the capacity in bytes of the cache of values read from
+// disk. A size of zero or less disables the cache.
+func WithCacheSize(size int64) Option {
+	return func(kv *KV) {
+		kv.cacheSize = size
+	}
+}
+
+// WithMaxOpenFiles limits the number of data file handles kept open for
+// reads. Handles in use are never closed, so the limit may be exceeded
+// briefly under load.
+func WithMaxOpenFiles(n int) Option {
+	return func(kv *KV) {
+		kv.maxOpenFiles = n
+	}
+}
+
+// WithMmap serves reads of flushed data from memory mapped files instead of
+// ReadAt calls. It falls back to ReadAt where mmap is not available.
+func WithMmap(enabled bool) Option {
+	return func(kv *KV) {
+		kv.mmap = enabled
+	}
+}
+
+// WithMemTableSize sets the size in bytes of keys and values the memtable
+// holds before it is queued for flushing.
+func WithMemTableSize(size int64) Option {
+	return func(kv *KV) {
+		kv.memTableSize = size
+	}
+}
+
+// WithMaxImmutableMemTables sets how many full memtables may wait to be
+// flushed before writers are stalled until the flusher catches up.
+func WithMaxImmutableMemTables(n int) Option {
+	return func(kv *KV) {
+		kv.maxImmutableMemTables = n
+	}
+}
+
+// WithCompression sets the codec used to compress values written to the
+// data file. Values that do not get smaller are stored uncompressed.
+func WithCompression(c Compression) Option {
+	return func(kv *KV) {
+		kv.compression = c
+	}
+}
+
+// WithKeyRing encrypts data, index and blob files with the active key of keys.
+// Files encrypted with other keys of the ring stay readable and are
+// re-encrypted with the active key when they are compacted.
+func WithKeyRing(keys *KeyRing) Option {
+	return func(kv *KV) {
+		kv.keys = keys
+	}
+}
+
+// WithValueThreshold sets the size in bytes from which values are stored in
+// blob files instead of the data file. A threshold of zero or less keeps
+// every value in the data file.
+func WithValueThreshold(size int) Option {
+	return func(kv *KV) {
+		kv.valueThreshold = size
+	}
+}
+
+// WithMaxBlobFileSize sets the size in bytes past which a new blob file is
+// started.
+func WithMaxBlobFileSize(size int64) Option {
+	return func(kv *KV) {
+		kv.maxBlobFileSize = size
+	}
+}
+
+// WithEventListener has the events of the store reported to listener; see
+// EventListener.
+func WithEventListener(listener EventListener) Option {
+	return func(kv *KV) {
+		kv.events = newEventQueue(listener)
+	}
+}
+
+// WithWatchBufferSize sets the number of events a watcher started with Watch
+// can fall behind by before it is dropped.
+func WithWatchBufferSize(n int) Option {
+	return func(kv *KV) {
+		kv.watchers.bufferSize = n
+	}
+}
+
+// WithSnapshotFile has an in-memory store (see OpenMemoryKV) load its
+// content from the snapshot at path when it is opened and write a new
+// snapshot there when it is closed, when SyncToDisk is called, and every
+// interval if interval is positive. It has no effect on stores on disk.
+func WithSnapshotFile(path string, interval time.Duration) Option {
+	return func(kv *KV) {
+		kv.snapshotPath = path
+		kv.snapshotInterval = interval
+	}
+}
+
+// WithReadOnly opens the store without writing to it. It takes a shared lock
+// rather than the exclusive one writers take, so it can be used next to a
+// running writer. Missing files are not created.
+func WithReadOnly() Option {
+	return func(kv *KV) {
+		kv.readOnly = true
+	}
+}
+
+// withMaintenance opens the store for writing while keeping out readers as
+// well as other writers.
+func withMaintenance() Option {
+	return func(kv *KV) {
+		kv.maintenance = true
+	}
+}
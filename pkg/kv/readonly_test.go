@@ -0,0 +1,81 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOnlyRejectsWrites(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	fillStore(dbPath, indexPath, 10)
+	manifest, _ := ioutil.ReadFile(dbPath + manifestSuffix)
+
+	store, err := OpenKV(dbPath, indexPath, WithReadOnly())
+	assetEqual(t, "err", nil, err)
+
+	assetEqual(t, "Set", ErrReadOnly, store.Set("key", "value"))
+	assetEqual(t, "Delete", ErrReadOnly, store.Delete("key_0"))
+	assetEqual(t, "SyncToDisk", ErrReadOnly, store.SyncToDisk())
+	assetEqual(t, "CompactData", ErrReadOnly, store.CompactData())
+	assetEqual(t, "CompactBlobs", ErrReadOnly, store.CompactBlobs(0))
+	assetEqual(t, "Reset", ErrReadOnly, store.Reset(nil))
+	store.Close()
+
+	after, _ := ioutil.ReadFile(dbPath + manifestSuffix)
+	assetEqual(t, "manifest", string(manifest), string(after))
+
+	checkStore(t, dbPath, indexPath, 10)
+}
+
+func TestReadOnlyDuringCompaction(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	N := 20
+	opts := []Option{WithValueThreshold(1024), WithMaxBlobFileSize(16 << 10), WithCacheSize(0)}
+
+	writer, err := OpenKV(dbPath, indexPath, opts...)
+	assetEqual(t, "err", nil, err)
+	defer writer.Close()
+
+	for i := 0; i < N; i++ {
+		writer.Set(fmt.Sprintf("key_%d", i), largeValue(i, 4096))
+		writer.Set(fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i))
+		writer.SyncToDisk()
+	}
+
+	reader, err := OpenKV(dbPath, indexPath, append(opts, WithReadOnly(), WithMaxOpenFiles(1))...)
+	assetEqual(t, "err", nil, err)
+	defer reader.Close()
+
+	// Replace every value and compact the old files away under the reader.
+	for i := 0; i < N; i++ {
+		writer.Set(fmt.Sprintf("key_%d", i), largeValue(i+N, 4096))
+		writer.Set(fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i+N))
+		writer.SyncToDisk()
+	}
+	writer.CompactData()
+	writer.CompactBlobs(0.5)
+
+	for i := 0; i < N; i++ {
+		value, _ := reader.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), largeValue(i, 4096), value)
+
+		value, _ = reader.Get(fmt.Sprintf("small_%d", i))
+		assetEqual(t, fmt.Sprintf("small_%d", i), fmt.Sprintf("value_%d", i), value)
+
+		value, _ = writer.Get(fmt.Sprintf("key_%d", i))
+		assetEqual(t, fmt.Sprintf("key_%d", i), largeValue(i+N, 4096), value)
+	}
+}
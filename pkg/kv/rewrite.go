@@ -0,0 +1,219 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// rewriter writes a new data file and index file, which then replace the
+// ones of the store at once. Compactions and ingests use it.
+type rewriter struct {
+	kv *KV
+
+	dbFile    *os.File
+	indexFile *os.File
+	format    fileFormat
+	blobs     *blobWriter
+
+	index       map[string]Index
+	offset      int64
+	indexOffset int64
+
+	// liveBlobs holds the blob files that copied records point to. Blob
+	// files from firstBlob on are kept whether or not they are referenced:
+	// values may still be appended to them.
+	liveBlobs map[uint64]bool
+	firstBlob uint64
+
+	// dropMemTables has the memtables emptied along with the install, for
+	// resets.
+	dropMemTables bool
+
+	installed bool
+}
+
+// newRewriter creates the new files next to the current ones. The caller
+// must hold flushLock until the rewriter is installed or closed.
+func (kv *KV) newRewriter() (*rewriter, error) {
+	// The new files are written with the active key, which is how data is
+	// re-encrypted after a key rotation.
+	w := &rewriter{
+		kv:          kv,
+		format:      kv.newFormat(),
+		blobs:       kv.newBlobWriter(),
+		index:       make(map[string]Index),
+		offset:      fileHeaderSize,
+		indexOffset: fileHeaderSize,
+		liveBlobs:   make(map[uint64]bool),
+		firstBlob:   kv.blobFile,
+	}
+
+	var err error
+	if w.indexFile, err = os.OpenFile(kv.indexPath+compactedSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	if w.dbFile, err = os.OpenFile(kv.dbPath+compactedSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		w.indexFile.Close()
+		return nil, err
+	}
+
+	if _, err := w.dbFile.Write(fileHeader(dataFileMagic, w.format.keyID)); err != nil {
+		w.close()
+		return nil, err
+	}
+	if _, err := w.indexFile.Write(fileHeader(indexFileMagic, w.format.keyID)); err != nil {
+		w.close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// writeValue writes key with value, to a blob file if it is large enough.
+func (w *rewriter) writeValue(key, value string) error {
+	buf := bytes.NewBuffer([]byte{})
+
+	stored, err := w.kv.appendValue(buf, w.blobs, w.format, key, value)
+	if err != nil {
+		return err
+	}
+	w.kv.countWritten(len(value), stored)
+
+	return w.write(key, buf)
+}
+
+// copyRecord copies the record of key at offset in h unless it is a
+// tombstone. Values stored in blob files are not copied, only the pointers to
+// them.
+func (w *rewriter) copyRecord(h *readHandle, key string, offset int64) error {
+	// Values are read straight from the file rather than through the cache
+	// so a pass over the store does not evict the working set.
+	val, flags, err := readKeyRecord(h, key, offset)
+	if err != nil {
+		w.kv.corruption(w.kv.dbPath, offset, err)
+		return err
+	}
+
+	if string(val) == "__KVGO_TOMBSTONE__" {
+		return nil
+	}
+
+	if flags&blobPointerFlag == 0 {
+		return w.writeValue(key, string(val))
+	}
+
+	p, err := decodeBlobPointer(val)
+	if err != nil {
+		return err
+	}
+	w.liveBlobs[p.file] = true
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := appendPointerRecord(buf, w.format, w.kv.keys, key, p); err != nil {
+		return err
+	}
+
+	return w.write(key, buf)
+}
+
+// write appends the record in buf to the data file and its index record to
+// the index file.
+func (w *rewriter) write(key string, buf *bytes.Buffer) error {
+	if _, err := w.dbFile.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.kv.countDiskWrite(buf.Len())
+
+	w.index[key] = Index{w.offset}
+	w.offset += int64(buf.Len())
+
+	buf.Reset()
+	if err := appendIndexRecord(buf, w.format, w.kv.keys, key, w.index[key].Offset); err != nil {
+		return err
+	}
+
+	if _, err := w.indexFile.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.indexOffset += int64(buf.Len())
+	w.kv.countDiskWrite(buf.Len())
+
+	return nil
+}
+
+// install makes the new files the files of the store and removes the blob
+// files that no record points to any more, returning how many there were.
+func (w *rewriter) install() (int, error) {
+	kv := w.kv
+
+	if err := w.dbFile.Sync(); err != nil {
+		return 0, err
+	}
+	if err := w.indexFile.Sync(); err != nil {
+		return 0, err
+	}
+	if err := w.blobs.close(); err != nil {
+		return 0, err
+	}
+
+	var garbage []uint64
+	for _, file := range kv.blobFiles() {
+		if !w.liveBlobs[file] && file < w.firstBlob {
+			garbage = append(garbage, file)
+		}
+	}
+	files := kv.blobFileList
+	kv.dropBlobFiles(garbage)
+
+	// Once this version is in the manifest the new files are the live ones,
+	// even if the renames below are interrupted.
+	if err := kv.commitAs(
+		filepath.Base(kv.dbPath+compactedSuffix), filepath.Base(kv.indexPath+compactedSuffix), w.offset, w.indexOffset,
+	); err != nil {
+		// The current files still point into the blob files that were to go.
+		kv.blobFileList = files
+		return 0, err
+	}
+	w.installed = true
+
+	kv.lock.Lock()
+	kv.installCompacted(kv.dbPath, kv.indexPath)
+
+	kv.index = w.index
+	kv.offset = w.offset
+	kv.indexOffset = w.indexOffset
+	kv.format = w.format
+	kv.indexFormat = w.format
+
+	if kv.cache != nil {
+		kv.cache.EvictFile(kv.fileID)
+	}
+	kv.fileID++
+
+	if w.dropMemTables {
+		kv.active = newMemTable()
+		kv.immutables = nil
+		kv.flushed.Broadcast()
+	}
+	kv.lock.Unlock()
+
+	// The new files are already live through the version committed above,
+	// which also dropped the garbage.
+	err := kv.commit()
+	kv.removeBlobFiles(garbage)
+
+	return len(garbage), err
+}
+
+// close closes the new files, removing them unless they were installed.
+func (w *rewriter) close() {
+	w.blobs.close()
+	w.dbFile.Close()
+	w.indexFile.Close()
+
+	if !w.installed {
+		os.Remove(w.kv.dbPath + compactedSuffix)
+		os.Remove(w.kv.indexPath + compactedSuffix)
+	}
+}
@@ -0,0 +1,227 @@
+package kv
+
+import (
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// statsSampleSize is the number of index entries Stats reads to estimate the
+// number of live keys and the bytes they take in the data and blob files.
+// Stores with fewer keys in their index are measured exactly.
+const statsSampleSize = 1000
+
+// Stats is a point-in-time summary of the state of a store, meant for
+// monitoring. Counters cover the time since the store was opened.
+type Stats struct {
+	// LiveKeys is the number of keys with a value. Keys that are only in the
+	// index are counted from a sample, so this is an estimate for large
+	// stores. TotalKeys also counts deleted keys whose tombstone has not been
+	// compacted away yet.
+	LiveKeys  int64
+	TotalKeys int64
+
+	// MemTables is the number of memtables, the active one included, and
+	// MemTableEntries and MemTableBytes what they hold.
+	MemTables       int
+	MemTableEntries int64
+	MemTableBytes   int64
+
+	DataFileSize  int64
+	IndexFileSize int64
+	BlobFileSizes map[uint64]int64
+
+	// DeadDataBytes and DeadBlobBytes estimate how much of the data and blob
+	// files is taken by overwritten values and tombstones, which is what a
+	// compaction would reclaim.
+	DeadDataBytes int64
+	DeadBlobBytes int64
+
+	Flushes     OperationStats
+	Compactions OperationStats
+
+	Cache       CacheStats
+	Compression CompressionStats
+
+	// UserBytesWritten is the number of key and value bytes passed to Set
+	// and Delete, DiskBytesWritten the number of bytes flushes and
+	// compactions wrote to the data, index and blob files.
+	UserBytesWritten int64
+	DiskBytesWritten int64
+
+	// Gets is the number of lookups and DiskReads the number of them that
+	// were not answered by a memtable or the cache and read the data file.
+	Gets      uint64
+	DiskReads uint64
+}
+
+// OperationStats counts runs of a background operation and describes the
+// last one.
+type OperationStats struct {
+	Count        uint64
+	LastFinished time.Time
+	LastDuration time.Duration
+}
+
+// WriteAmplification returns how many bytes were written to disk for every
+// byte written to the store.
+func (s Stats) WriteAmplification() float64 {
+	if s.UserBytesWritten == 0 {
+		return 0
+	}
+
+	return float64(s.DiskBytesWritten) / float64(s.UserBytesWritten)
+}
+
+// ReadAmplification returns the average number of data file reads per Get.
+func (s Stats) ReadAmplification() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+
+	return float64(s.DiskReads) / float64(s.Gets)
+}
+
+// HitRate returns the share of cache lookups that were hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns a summary of the state of the store.
+func (kv *KV) Stats() Stats {
+	s := Stats{
+		Cache:            kv.CacheStats(),
+		Compression:      kv.CompressionStats(),
+		UserBytesWritten: atomic.LoadInt64(&kv.userBytes),
+		DiskBytesWritten: atomic.LoadInt64(&kv.diskBytes),
+		Gets:             atomic.LoadUint64(&kv.gets),
+		DiskReads:        atomic.LoadUint64(&kv.diskReads),
+		BlobFileSizes:    make(map[uint64]int64),
+	}
+
+	kv.statsLock.Lock()
+	s.Flushes = kv.flushes
+	s.Compactions = kv.compactions
+	kv.statsLock.Unlock()
+
+	kv.lock.RLock()
+	memTables := append(append([]*memTable{}, kv.immutables...), kv.active)
+
+	// deleted tells for every key in a memtable whether its newest value is
+	// a tombstone.
+	deleted := make(map[string]bool)
+	for _, mt := range memTables {
+		s.MemTableEntries += mt.len()
+		s.MemTableBytes += mt.bytes()
+
+		for k, v := range mt.items() {
+			deleted[k] = v == "__KVGO_TOMBSTONE__"
+		}
+	}
+	s.MemTables = len(memTables)
+
+	indexKeys := int64(len(kv.index))
+	var shadowed int64
+	sample := make([]int64, 0, statsSampleSize)
+	for k, v := range kv.index {
+		if _, ok := deleted[k]; ok {
+			shadowed++
+		}
+		if len(sample) < statsSampleSize {
+			sample = append(sample, v.Offset)
+		}
+	}
+	h, err := kv.files.acquire(kv.dbPath)
+	kv.lock.RUnlock()
+
+	s.TotalKeys = indexKeys + int64(len(deleted)) - shadowed
+	for _, d := range deleted {
+		if !d {
+			s.LiveKeys++
+		}
+	}
+
+	if kv.inMemory {
+		return s
+	}
+
+	if st, err := os.Stat(kv.dbPath); err == nil {
+		s.DataFileSize = st.Size()
+	}
+	if st, err := os.Stat(kv.indexPath); err == nil {
+		s.IndexFileSize = st.Size()
+	}
+	var blobBytes int64
+	for _, file := range kv.findBlobFiles() {
+		if st, err := os.Stat(kv.blobPath(file)); err == nil {
+			s.BlobFileSizes[file] = st.Size()
+			blobBytes += st.Size() - fileHeaderSize
+		}
+	}
+
+	if err != nil {
+		return s
+	}
+	defer kv.files.release(h)
+
+	var live, liveData, liveBlob int64
+	for _, offset := range sample {
+		size, err := recordSize(h, offset, math.MaxInt64)
+		if err != nil {
+			continue
+		}
+		_, val, flags, err := readRecord(h, offset)
+		if err != nil || string(val) == "__KVGO_TOMBSTONE__" {
+			continue
+		}
+
+		live++
+		liveData += size
+		if flags&blobPointerFlag != 0 {
+			if p, err := decodeBlobPointer(val); err == nil {
+				liveBlob += p.size()
+			}
+		}
+	}
+
+	if len(sample) > 0 {
+		scale := float64(indexKeys) / float64(len(sample))
+
+		s.LiveKeys += int64(math.Round(float64(live) * scale * float64(indexKeys-shadowed) / float64(indexKeys)))
+		liveData = int64(float64(liveData) * scale)
+		liveBlob = int64(float64(liveBlob) * scale)
+	}
+
+	s.DeadDataBytes = maxInt64(s.DataFileSize-h.format.headerSize()-liveData, 0)
+	s.DeadBlobBytes = maxInt64(blobBytes-liveBlob, 0)
+
+	return s
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// trackOperation records that an operation which started at start has just
+// finished.
+func (kv *KV) trackOperation(op *OperationStats, start time.Time) {
+	now := time.Now()
+
+	kv.statsLock.Lock()
+	op.Count++
+	op.LastFinished = now
+	op.LastDuration = now.Sub(start)
+	kv.statsLock.Unlock()
+}
+
+func (kv *KV) countDiskWrite(n int) {
+	atomic.AddInt64(&kv.diskBytes, int64(n))
+}
@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	N := 100
+	for i := 0; i < N; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	store.SyncToDisk()
+
+	for i := 0; i < 10; i++ {
+		store.Delete(fmt.Sprintf("key_%d", i))
+	}
+	store.Set("key_10", "new value")
+	store.Set("only_in_memory", "value")
+
+	stats := store.Stats()
+	assetEqual(t, "live keys", int64(N-10+1), stats.LiveKeys)
+	assetEqual(t, "total keys", int64(N+1), stats.TotalKeys)
+	assetEqual(t, "memtables", 1, stats.MemTables)
+	assetEqual(t, "memtable entries", int64(12), stats.MemTableEntries)
+	assetEqual(t, "flushes", uint64(1), stats.Flushes.Count)
+	assetEqual(t, "compactions", uint64(0), stats.Compactions.Count)
+	assetEqual(t, "dead data bytes", int64(0), stats.DeadDataBytes)
+
+	if stats.DataFileSize == 0 || stats.IndexFileSize == 0 {
+		t.Errorf("Expected data and index file sizes, got %d and %d\n", stats.DataFileSize, stats.IndexFileSize)
+	}
+	if stats.Flushes.LastFinished.IsZero() {
+		t.Errorf("Expected the time of the last flush\n")
+	}
+	if stats.WriteAmplification() <= 1 {
+		t.Errorf("Expected a write amplification above 1, got %f\n", stats.WriteAmplification())
+	}
+
+	store.SyncToDisk()
+
+	stats = store.Stats()
+	assetEqual(t, "live keys after flush", int64(N-10+1), stats.LiveKeys)
+	if stats.DeadDataBytes == 0 {
+		t.Errorf("Expected dead bytes after overwriting and deleting keys\n")
+	}
+
+	store.CompactData()
+
+	stats = store.Stats()
+	assetEqual(t, "live keys after compaction", int64(N-10+1), stats.LiveKeys)
+	assetEqual(t, "total keys after compaction", int64(N-10+1), stats.TotalKeys)
+	assetEqual(t, "dead data bytes after compaction", int64(0), stats.DeadDataBytes)
+	assetEqual(t, "compactions", uint64(1), stats.Compactions.Count)
+}
+
+func TestStatsReads(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+	defer store.Close()
+
+	store.Set("a", "1")
+	store.Get("a")
+	store.SyncToDisk()
+
+	store.Get("a")
+	store.Get("a")
+	store.Get("missing")
+
+	stats := store.Stats()
+	assetEqual(t, "gets", uint64(4), stats.Gets)
+	assetEqual(t, "disk reads", uint64(1), stats.DiskReads)
+	assetEqual(t, "read amplification", 0.25, stats.ReadAmplification())
+	assetEqual(t, "cache hit rate", 0.5, stats.Cache.HitRate())
+}
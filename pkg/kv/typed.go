@@ -0,0 +1,97 @@
+package kv
+
+import "fmt"
+
+// Typed is a view of an Engine, such as a KV, with keys of type K and values
+// of type V, converted with a KeyEncoder and a Codec.
+type Typed[K comparable, V any] struct {
+	engine Engine
+	keys   KeyEncoder[K]
+	values Codec[V]
+}
+
+// NewTyped returns a Typed view of engine. For example, a store of User
+// structs keyed by id:
+//
+//	users := kv.NewTyped[int64, User](db, kv.IntKey[int64]{}, kv.JSONCodec[User]{})
+func NewTyped[K comparable, V any](engine Engine, keys KeyEncoder[K], values Codec[V]) *Typed[K, V] {
+	return &Typed[K, V]{engine: engine, keys: keys, values: values}
+}
+
+// Get returns the value of key and whether it exists. An error is returned
+// if the value cannot be decoded.
+func (t *Typed[K, V]) Get(key K) (V, bool, error) {
+	var value V
+
+	data, ok := t.engine.Get(t.keys.EncodeKey(key))
+	if !ok {
+		return value, false, nil
+	}
+
+	value, err := t.values.Decode([]byte(data))
+	if err != nil {
+		return value, true, fmt.Errorf("kv: decoding value: %w", err)
+	}
+
+	return value, true, nil
+}
+
+func (t *Typed[K, V]) Set(key K, value V) error {
+	data, err := t.values.Encode(value)
+	if err != nil {
+		return fmt.Errorf("kv: encoding value: %w", err)
+	}
+
+	return t.engine.Set(t.keys.EncodeKey(key), string(data))
+}
+
+func (t *Typed[K, V]) Delete(key K) error {
+	return t.engine.Delete(t.keys.EncodeKey(key))
+}
+
+// SetMany sets every key of values in one batch; see Engine.Batch.
+func (t *Typed[K, V]) SetMany(values map[K]V) error {
+	ops := make([]BatchOp, 0, len(values))
+	for key, value := range values {
+		data, err := t.values.Encode(value)
+		if err != nil {
+			return fmt.Errorf("kv: encoding value: %w", err)
+		}
+
+		ops = append(ops, BatchOp{Key: t.keys.EncodeKey(key), Value: string(data)})
+	}
+
+	return t.engine.Batch(ops)
+}
+
+// Iterate calls fn with every key and value in the order of their encoded
+// keys, until fn returns false. Keys the key encoder rejects with
+// ErrKeyMismatch belong to something else and are skipped. Iteration stops
+// with an error at the first key or value that cannot be decoded otherwise.
+func (t *Typed[K, V]) Iterate(fn func(key K, value V) bool) error {
+	var decodeErr error
+
+	err := t.engine.Iterate(func(rawKey, rawValue string) bool {
+		key, err := t.keys.DecodeKey(rawKey)
+		if err == ErrKeyMismatch {
+			return true
+		}
+		if err != nil {
+			decodeErr = fmt.Errorf("kv: decoding key %q: %w", rawKey, err)
+			return false
+		}
+
+		value, err := t.values.Decode([]byte(rawValue))
+		if err != nil {
+			decodeErr = fmt.Errorf("kv: decoding value of %q: %w", rawKey, err)
+			return false
+		}
+
+		return fn(key, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	return decodeErr
+}
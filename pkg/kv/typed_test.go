@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+)
+
+type testUser struct {
+	Name  string
+	Age   int
+	Tags  []string
+	Admin bool
+}
+
+func testCodec(t *testing.T, name string, c Codec[testUser]) {
+	store := NewMemoryKV()
+	defer store.Close()
+
+	users := NewTyped[string, testUser](store, StringKey{}, c)
+
+	alice := testUser{Name: "Alice", Age: 30, Tags: []string{"a", "b"}, Admin: true}
+	assetEqual(t, name+" set", nil, users.Set("alice", alice))
+
+	user, ok, err := users.Get("alice")
+	assetEqual(t, name+" err", nil, err)
+	assetEqual(t, name+" ok", true, ok)
+	assetEqual(t, name+" name", alice.Name, user.Name)
+	assetEqual(t, name+" age", alice.Age, user.Age)
+	assetEqual(t, name+" tags", "a,b", strings.Join(user.Tags, ","))
+	assetEqual(t, name+" admin", true, user.Admin)
+
+	_, ok, err = users.Get("bob")
+	assetEqual(t, name+" missing err", nil, err)
+	assetEqual(t, name+" missing", false, ok)
+
+	users.Delete("alice")
+	_, ok, _ = users.Get("alice")
+	assetEqual(t, name+" deleted", false, ok)
+}
+
+func TestTypedCodecs(t *testing.T) {
+	testCodec(t, "json", JSONCodec[testUser]{})
+	testCodec(t, "gob", GobCodec[testUser]{})
+	testCodec(t, "msgpack", MsgpackCodec[testUser]{})
+}
+
+func TestTypedProtoCodec(t *testing.T) {
+	store := NewMemoryKV()
+	defer store.Close()
+
+	timeouts := NewTyped[string, *duration.Duration](store, StringKey{}, ProtoCodec[*duration.Duration]{})
+
+	timeouts.Set("read", &duration.Duration{Seconds: 3, Nanos: 500})
+
+	timeout, ok, err := timeouts.Get("read")
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "ok", true, ok)
+	assetEqual(t, "seconds", int64(3), timeout.Seconds)
+	assetEqual(t, "nanos", int32(500), timeout.Nanos)
+}
+
+func TestTypedIterate(t *testing.T) {
+	store := NewMemoryKV()
+	defer store.Close()
+
+	store.Set("unrelated", "not json")
+
+	scores := NewTyped[int64, int](store, PrefixKey[int64]{Prefix: "score/", Keys: IntKey[int64]{}}, JSONCodec[int]{})
+
+	values := make(map[int64]int)
+	for _, k := range []int64{5, -3, 1000, 0, -1000} {
+		values[k] = int(k) * 2
+	}
+	assetEqual(t, "set many", nil, scores.SetMany(values))
+
+	var keys []string
+	err := scores.Iterate(func(key int64, value int) bool {
+		assetEqual(t, fmt.Sprintf("value of %d", key), int(key)*2, value)
+		keys = append(keys, fmt.Sprint(key))
+		return true
+	})
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "keys", "-1000,-3,0,5,1000", strings.Join(keys, ","))
+
+	store.Set("score/broken!!", "not json")
+	err = scores.Iterate(func(key int64, value int) bool { return true })
+	if err == nil {
+		t.Errorf("Expected an error for a value that cannot be decoded\n")
+	}
+}
+
+func TestKeyEncoders(t *testing.T) {
+	ints := IntKey[int32]{}
+	for _, k := range []int32{-2147483648, -1, 0, 1, 2147483647} {
+		decoded, err := ints.DecodeKey(ints.EncodeKey(k))
+		assetEqual(t, "err", nil, err)
+		assetEqual(t, fmt.Sprint(k), k, decoded)
+	}
+
+	uints := UintKey[uint64]{}
+	decoded, err := uints.DecodeKey(uints.EncodeKey(1 << 63))
+	assetEqual(t, "err", nil, err)
+	assetEqual(t, "uint", uint64(1<<63), decoded)
+
+	_, err = ints.DecodeKey("short")
+	assetEqual(t, "mismatch", ErrKeyMismatch, err)
+
+	_, err = PrefixKey[string]{Prefix: "user/", Keys: StringKey{}}.DecodeKey("order/1")
+	assetEqual(t, "prefix mismatch", ErrKeyMismatch, err)
+}
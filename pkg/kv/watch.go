@@ -0,0 +1,162 @@
+package kv
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWatchBufferSize is the number of events a watcher can fall behind
+// by before it is dropped.
+const DefaultWatchBufferSize = 1024
+
+// EventType tells what happened to a key.
+type EventType int
+
+const (
+	EventSet EventType = iota
+	EventDelete
+
+	// EventOverflow is the last event of a watcher that fell too far
+	// behind; see KV.Watch.
+	EventOverflow
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change to a key delivered to watchers. Value is empty for
+// deletes.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value string
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+// watchers delivers the changes made to a store to its watchers.
+type watchers struct {
+	// count is the number of watchers, read without lock so that writes
+	// skip lock while nobody watches.
+	count int32
+
+	lock       sync.Mutex
+	all        map[*watcher]struct{}
+	closed     bool
+	bufferSize int
+}
+
+// Watch returns a channel that receives an event for every key starting with
+// prefix that is set or deleted from now on, in the order the changes are
+// made, and a function that stops the watch and closes the channel. Ingest
+// and Reset do not send events.
+//
+// Events are buffered up to the size set with WithWatchBufferSize. A watcher
+// that falls further behind is dropped rather than hold up writes: it
+// receives an EventOverflow event and its channel is closed, after which it
+// should read the keys it cares about again and start a new watch. Closing
+// the store closes every watch channel.
+func (kv *KV) Watch(prefix string) (<-chan Event, func()) {
+	size := kv.watchers.bufferSize
+	if size < 1 {
+		size = DefaultWatchBufferSize
+	}
+
+	// The extra slot is kept free for the EventOverflow event.
+	w := &watcher{prefix: prefix, ch: make(chan Event, size+1)}
+
+	// Writers check whether anybody watches while holding lock for reading,
+	// so once lock is taken here no write can still miss the new watcher.
+	kv.lock.Lock()
+	kv.watchers.lock.Lock()
+	if kv.watchers.closed {
+		close(w.ch)
+	} else {
+		if kv.watchers.all == nil {
+			kv.watchers.all = make(map[*watcher]struct{})
+		}
+		kv.watchers.all[w] = struct{}{}
+		atomic.AddInt32(&kv.watchers.count, 1)
+	}
+	kv.watchers.lock.Unlock()
+	kv.lock.Unlock()
+
+	return w.ch, func() {
+		kv.watchers.lock.Lock()
+		kv.watchers.remove(w)
+		kv.watchers.lock.Unlock()
+	}
+}
+
+// change makes a change to key with apply and sends the watchers an event
+// for it, value being the tombstone for deletes. Events are sent in the order
+// changes are made. The caller must hold lock, at least for reading.
+func (kv *KV) change(key, value string, apply func()) {
+	if atomic.LoadInt32(&kv.watchers.count) == 0 {
+		apply()
+		return
+	}
+
+	kv.watchers.lock.Lock()
+	defer kv.watchers.lock.Unlock()
+
+	apply()
+
+	e := Event{Type: EventSet, Key: key, Value: value}
+	if value == "__KVGO_TOMBSTONE__" {
+		e = Event{Type: EventDelete, Key: key}
+	}
+	kv.watchers.send(e)
+}
+
+// send sends e to the watchers of its key. The caller must hold lock.
+func (ws *watchers) send(e Event) {
+	for w := range ws.all {
+		if !strings.HasPrefix(e.Key, w.prefix) {
+			continue
+		}
+
+		if len(w.ch) == cap(w.ch)-1 {
+			w.ch <- Event{Type: EventOverflow}
+			ws.remove(w)
+			continue
+		}
+		w.ch <- e
+	}
+}
+
+// remove stops w. The caller must hold lock.
+func (ws *watchers) remove(w *watcher) {
+	if _, ok := ws.all[w]; !ok {
+		return
+	}
+
+	delete(ws.all, w)
+	atomic.AddInt32(&ws.count, -1)
+	close(w.ch)
+}
+
+// closeAll stops every watcher, and those started later right away.
+func (ws *watchers) closeAll() {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	ws.closed = true
+	for w := range ws.all {
+		ws.remove(w)
+	}
+}
@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "testStore")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewKV(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10)
+
+	events, cancel := store.Watch("config/")
+
+	store.Set("config/a", "1")
+	store.Set("other", "1")
+	store.Batch([]BatchOp{{Key: "config/b", Value: "2"}, {Key: "config/a", Delete: true}})
+	store.Delete("config/b")
+
+	expected := []Event{
+		{Type: EventSet, Key: "config/a", Value: "1"},
+		{Type: EventSet, Key: "config/b", Value: "2"},
+		{Type: EventDelete, Key: "config/a"},
+		{Type: EventDelete, Key: "config/b"},
+	}
+	for i, e := range expected {
+		assetEqual(t, fmt.Sprintf("event %d", i), e, <-events)
+	}
+
+	cancel()
+	_, ok := <-events
+	assetEqual(t, "closed after cancel", false, ok)
+	cancel()
+
+	events, _ = store.Watch("")
+	store.Close()
+	_, ok = <-events
+	assetEqual(t, "closed after close", false, ok)
+}
+
+func TestWatchOverflow(t *testing.T) {
+	store := NewMemoryKV(WithWatchBufferSize(3))
+	defer store.Close()
+
+	slow, _ := store.Watch("")
+	fast, cancel := store.Watch("")
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		store.Set(fmt.Sprintf("key_%d", i), "value")
+		assetEqual(t, fmt.Sprintf("fast key_%d", i), fmt.Sprintf("key_%d", i), (<-fast).Key)
+	}
+	store.Delete("key_0")
+	assetEqual(t, "fast delete", Event{Type: EventDelete, Key: "key_0"}, <-fast)
+
+	var got []EventType
+	for e := range slow {
+		got = append(got, e.Type)
+	}
+	assetEqual(t, "slow", fmt.Sprint([]EventType{EventSet, EventSet, EventSet, EventOverflow}), fmt.Sprint(got))
+}
@@ -1,16 +1,27 @@
 package server
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
 	"net"
 
+	"github.com/hashicorp/raft"
+	kv "github.com/kgantsov/kvgo/pkg/kv"
 	log "github.com/sirupsen/logrus"
 	context "golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	port = ":50051"
+
+	// backupChunkSize is the size of the chunks backups are streamed in.
+	backupChunkSize = 64 << 10
 )
 
 type server struct {
@@ -39,7 +50,10 @@ func ListenAndServGrpc(port string, store *Store) {
 }
 
 func (s *server) Set(ctx context.Context, in *SetRequest) (*SetResponse, error) {
-	s.store.Set(in.Key, in.Value)
+	if err := s.store.SetContext(ctx, in.Key, in.Value); err != nil {
+		return nil, statusError(err)
+	}
+
 	return &SetResponse{Exist: true}, nil
 }
 
@@ -53,15 +67,184 @@ func (s *server) Get(ctx context.Context, in *GetRequest) (*GetResponse, error)
 }
 
 func (s *server) Del(ctx context.Context, in *DelRequest) (*DelResponse, error) {
-	err := s.store.Delete(in.Key)
-	if err == nil {
-		return &DelResponse{Exist: false}, nil
-	} else {
-		return &DelResponse{Exist: true}, nil
+	if err := s.store.DeleteContext(ctx, in.Key); err != nil {
+		return nil, statusError(err)
 	}
+
+	return &DelResponse{Exist: false}, nil
 }
 
 func (s *server) Join(ctx context.Context, in *JoinRequest) (*JoinResponse, error) {
-	s.store.Join(in.NodeID, in.Addr)
+	if err := s.store.Join(in.NodeID, in.Addr); err != nil {
+		return nil, statusError(err)
+	}
+
 	return &JoinResponse{Joined: true}, nil
 }
+
+// statusError returns err, an error of the store, as a gRPC status error
+// with the code that tells the client what to do about it.
+func statusError(err error) error {
+	code := codes.Internal
+
+	switch {
+	case errors.Is(err, raft.ErrNotLeader):
+		// The request has to be sent to the leader.
+		code = codes.FailedPrecondition
+	case errors.Is(err, raft.ErrLeadershipLost), errors.Is(err, raft.ErrEnqueueTimeout), errors.Is(err, raft.ErrRaftShutdown):
+		code = codes.Unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, ErrIndexTooOld):
+		code = codes.OutOfRange
+	case errors.Is(err, kv.ErrReadOnly):
+		code = codes.FailedPrecondition
+	case errors.Is(err, kv.ErrUnsupportedTTL), errors.Is(err, kv.ErrCorruptDump):
+		code = codes.InvalidArgument
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// Backup streams a backup archive of the store, as written by
+// kv.KV.BackupSince, in chunks.
+func (s *server) Backup(in *BackupRequest, stream KV_BackupServer) error {
+	var since *kv.BackupInfo
+	if len(in.Since) > 0 {
+		since = new(kv.BackupInfo)
+		if err := json.Unmarshal(in.Since, since); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	w := bufio.NewWriterSize(backupWriter{stream}, backupChunkSize)
+	if _, err := s.store.BackupContext(stream.Context(), w, since); err != nil {
+		return statusError(err)
+	}
+	if err := w.Flush(); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+type backupWriter struct {
+	stream KV_BackupServer
+}
+
+func (w backupWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&BackupChunk{Data: p}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Import reads a dump streamed in chunks and applies it through the Raft log;
+// see Store.Import.
+func (s *server) Import(stream KV_ImportServer) error {
+	return s.receiveDump(stream, func(r io.Reader, format kv.DumpFormat, opts []kv.DumpOption) (int, error) {
+		return s.store.ImportContext(stream.Context(), r, format, DefaultImportBatchSize, opts...)
+	})
+}
+
+// Ingest reads a dump streamed in chunks and ingests it; see Store.Ingest.
+func (s *server) Ingest(stream KV_IngestServer) error {
+	return s.receiveDump(stream, func(r io.Reader, format kv.DumpFormat, opts []kv.DumpOption) (int, error) {
+		return s.store.IngestContext(stream.Context(), r, format, opts...)
+	})
+}
+
+// dumpStream is the stream of the Import and Ingest calls.
+type dumpStream interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ImportChunk, error)
+}
+
+// receiveDump passes the dump read from stream to load and replies with the
+// number of entries it loaded.
+func (s *server) receiveDump(stream dumpStream, load func(r io.Reader, format kv.DumpFormat, opts []kv.DumpOption) (int, error)) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&ImportResponse{})
+	}
+	if err != nil {
+		return err
+	}
+
+	format, err := kv.ParseDumpFormat(first.Format)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var opts []kv.DumpOption
+	if first.DropTtls {
+		opts = append(opts, kv.WithDroppedTTLs())
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for chunk := first; ; {
+			if _, err := pw.Write(chunk.Data); err != nil {
+				return
+			}
+
+			next, err := stream.Recv()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			chunk = next
+		}
+	}()
+
+	n, err := load(pr, format, opts)
+	// Stops the goroutine above if the import ended early.
+	pr.Close()
+	if err != nil {
+		return statusError(err)
+	}
+
+	return stream.SendAndClose(&ImportResponse{Imported: int64(n)})
+}
+
+// Watch streams the changes to keys with the requested prefix; see
+// Store.Watch. A watcher that falls behind gets a ResourceExhausted error and
+// can watch again from the index of the last event it received.
+func (s *server) Watch(in *WatchRequest, stream KV_WatchServer) error {
+	events, cancel, err := s.store.Watch(in.Prefix, in.FromIndex)
+	if err != nil {
+		return statusError(err)
+	}
+	defer cancel()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e.Type == kv.EventOverflow.String() {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind")
+			}
+
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Subscribe streams the changes of the Raft log of the node it is called on;
+// see Store.Subscribe.
+func (s *server) Subscribe(in *SubscribeRequest, stream KV_SubscribeServer) error {
+	return s.store.Subscribe(stream.Context(), in.FromIndex, stream.Send)
+}
@@ -1,16 +1,22 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	fmt "fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/raft"
+	kv "github.com/kgantsov/kvgo/pkg/kv"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGRPCServerBasic(t *testing.T) {
@@ -26,7 +32,10 @@ func TestGRPCServerBasic(t *testing.T) {
 	raftDir := filepath.Join(".", "raft")
 
 	log.Info("Creating storage...")
-	store := NewStore(dbPath, indexPath, 1000, 10000, raftDir, raftAddr)
+	store, err := NewStore(dbPath, indexPath, 1000, 10000, raftDir, raftAddr)
+	if err != nil {
+		log.Fatalf("failed to create store: %s", err.Error())
+	}
 
 	if err := store.Open(true, "node1"); err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
@@ -75,4 +84,134 @@ func TestGRPCServerBasic(t *testing.T) {
 			t.Errorf("Expected `%s`. Got `%v`\n", value, getResp.Value)
 		}
 	}
+
+	stream, err := c.Backup(ctx, &BackupRequest{})
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	archive := bytes.NewBuffer([]byte{})
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+		archive.Write(chunk.Data)
+	}
+
+	stream, err = c.Backup(ctx, &BackupRequest{Since: []byte("not json")})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected `%v`. Got `%v`\n", codes.InvalidArgument, err)
+	}
+
+	importStream, err := c.Import(ctx)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	dump := `{"key":"imported_1","value":"one"}` + "\n" + `{"key":"imported_2","value":"two"}` + "\n"
+	importStream.Send(&ImportChunk{Format: "jsonl", Data: []byte(dump[:20])})
+	importStream.Send(&ImportChunk{Data: []byte(dump[20:])})
+
+	importResp, err := importStream.CloseAndRecv()
+	if err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	} else if importResp.Imported != 2 {
+		t.Errorf("Expected `2`. Got `%v`\n", importResp.Imported)
+	}
+
+	getResp, err := c.Get(ctx, &GetRequest{Key: "imported_2"})
+	if err != nil || getResp.Value != "two" {
+		t.Errorf("Expected `two`. Got `%v` (%v)\n", getResp.GetValue(), err)
+	}
+
+	// Entries with a ttl are refused unless the first chunk drops them.
+	expiring := []byte(`{"key":"imported_3","value":"three","ttl":60}` + "\n")
+	for _, drop := range []bool{false, true} {
+		importStream, err = c.Import(ctx)
+		if err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+		importStream.Send(&ImportChunk{Format: "jsonl", Data: expiring, DropTtls: drop})
+
+		_, err = importStream.CloseAndRecv()
+		if code := status.Code(err); drop && code != codes.OK || !drop && code != codes.InvalidArgument {
+			t.Errorf("Unexpected error `%v` with drop_ttls `%v`\n", err, drop)
+		}
+	}
+
+	info, err := kv.ReadBackupInfo(archive)
+	if err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	} else if info.Data.Size == 0 {
+		t.Errorf("Expected the backup to hold the data file\n")
+	}
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	watchStream, err := c.Watch(watchCtx, &WatchRequest{Prefix: "watched_"})
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	// Give the server a moment to register the watch.
+	time.Sleep(100 * time.Millisecond)
+	c.Set(ctx, &SetRequest{Key: "watched_1", Value: "one"})
+
+	event, err := watchStream.Recv()
+	if err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	} else if event.Type != "set" || event.Key != "watched_1" || event.Value != "one" || event.Index == 0 {
+		t.Errorf("Expected `set watched_1 one`. Got `%v`\n", event)
+	}
+	stopWatch()
+
+	tooOld, err := c.Watch(ctx, &WatchRequest{FromIndex: 1})
+	if err == nil {
+		_, err = tooOld.Recv()
+	}
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("Expected `%v`. Got `%v`\n", codes.OutOfRange, err)
+	}
+
+	changes, err := c.Subscribe(ctx, &SubscribeRequest{})
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	change, err := changes.Recv()
+	if err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	} else if change.Op != "set" || change.Index == 0 {
+		t.Errorf("Expected a set. Got `%v`\n", change)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{raft.ErrNotLeader, codes.FailedPrecondition},
+		{raft.ErrLeadershipLost, codes.Unavailable},
+		{raft.ErrEnqueueTimeout, codes.Unavailable},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{ErrIndexTooOld, codes.OutOfRange},
+		{kv.ErrReadOnly, codes.FailedPrecondition},
+		{fmt.Errorf("line 3: %w", kv.ErrUnsupportedTTL), codes.InvalidArgument},
+		{fmt.Errorf("backup: %w", context.Canceled), codes.Canceled},
+		{fmt.Errorf("disk is full"), codes.Internal},
+	}
+
+	for _, test := range tests {
+		err := statusError(test.err)
+		if status.Code(err) != test.code || status.Convert(err).Message() != test.err.Error() {
+			t.Errorf("Expected `%v` for `%v`. Got `%v`\n", test.code, test.err, err)
+		}
+	}
 }
@@ -16,6 +16,14 @@ It has these top-level messages:
 	DelResponse
 	JoinRequest
 	JoinResponse
+	BackupRequest
+	BackupChunk
+	ImportChunk
+	ImportResponse
+	WatchRequest
+	WatchEvent
+	SubscribeRequest
+	ChangeEvent
 */
 package server
 
@@ -191,6 +199,214 @@ func (m *JoinResponse) GetJoined() bool {
 	return false
 }
 
+type BackupRequest struct {
+	Since []byte `protobuf:"bytes,1,opt,name=since,proto3" json:"since,omitempty"`
+}
+
+func (m *BackupRequest) Reset()                    { *m = BackupRequest{} }
+func (m *BackupRequest) String() string            { return proto.CompactTextString(m) }
+func (*BackupRequest) ProtoMessage()               {}
+func (*BackupRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *BackupRequest) GetSince() []byte {
+	if m != nil {
+		return m.Since
+	}
+	return nil
+}
+
+type BackupChunk struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *BackupChunk) Reset()                    { *m = BackupChunk{} }
+func (m *BackupChunk) String() string            { return proto.CompactTextString(m) }
+func (*BackupChunk) ProtoMessage()               {}
+func (*BackupChunk) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *BackupChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type ImportChunk struct {
+	Format   string `protobuf:"bytes,1,opt,name=format" json:"format,omitempty"`
+	Data     []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	DropTtls bool   `protobuf:"varint,3,opt,name=drop_ttls,json=dropTtls" json:"drop_ttls,omitempty"`
+}
+
+func (m *ImportChunk) Reset()                    { *m = ImportChunk{} }
+func (m *ImportChunk) String() string            { return proto.CompactTextString(m) }
+func (*ImportChunk) ProtoMessage()               {}
+func (*ImportChunk) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ImportChunk) GetFormat() string {
+	if m != nil {
+		return m.Format
+	}
+	return ""
+}
+
+func (m *ImportChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *ImportChunk) GetDropTtls() bool {
+	if m != nil {
+		return m.DropTtls
+	}
+	return false
+}
+
+type ImportResponse struct {
+	Imported int64 `protobuf:"varint,1,opt,name=imported" json:"imported,omitempty"`
+}
+
+func (m *ImportResponse) Reset()                    { *m = ImportResponse{} }
+func (m *ImportResponse) String() string            { return proto.CompactTextString(m) }
+func (*ImportResponse) ProtoMessage()               {}
+func (*ImportResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *ImportResponse) GetImported() int64 {
+	if m != nil {
+		return m.Imported
+	}
+	return 0
+}
+
+type WatchRequest struct {
+	Prefix    string `protobuf:"bytes,1,opt,name=prefix" json:"prefix,omitempty"`
+	FromIndex uint64 `protobuf:"varint,2,opt,name=from_index,json=fromIndex" json:"from_index,omitempty"`
+}
+
+func (m *WatchRequest) Reset()                    { *m = WatchRequest{} }
+func (m *WatchRequest) String() string            { return proto.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()               {}
+func (*WatchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *WatchRequest) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *WatchRequest) GetFromIndex() uint64 {
+	if m != nil {
+		return m.FromIndex
+	}
+	return 0
+}
+
+type WatchEvent struct {
+	Type  string `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
+	Key   string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,3,opt,name=value" json:"value,omitempty"`
+	Index uint64 `protobuf:"varint,4,opt,name=index" json:"index,omitempty"`
+}
+
+func (m *WatchEvent) Reset()                    { *m = WatchEvent{} }
+func (m *WatchEvent) String() string            { return proto.CompactTextString(m) }
+func (*WatchEvent) ProtoMessage()               {}
+func (*WatchEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *WatchEvent) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *WatchEvent) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *WatchEvent) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *WatchEvent) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+type SubscribeRequest struct {
+	FromIndex uint64 `protobuf:"varint,1,opt,name=from_index,json=fromIndex" json:"from_index,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()                    { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()               {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *SubscribeRequest) GetFromIndex() uint64 {
+	if m != nil {
+		return m.FromIndex
+	}
+	return 0
+}
+
+type ChangeEvent struct {
+	Index     uint64 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Op        string `protobuf:"bytes,2,opt,name=op" json:"op,omitempty"`
+	Key       string `protobuf:"bytes,3,opt,name=key" json:"key,omitempty"`
+	Value     string `protobuf:"bytes,4,opt,name=value" json:"value,omitempty"`
+	Timestamp int64  `protobuf:"varint,5,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *ChangeEvent) Reset()                    { *m = ChangeEvent{} }
+func (m *ChangeEvent) String() string            { return proto.CompactTextString(m) }
+func (*ChangeEvent) ProtoMessage()               {}
+func (*ChangeEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *ChangeEvent) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *ChangeEvent) GetOp() string {
+	if m != nil {
+		return m.Op
+	}
+	return ""
+}
+
+func (m *ChangeEvent) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *ChangeEvent) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *ChangeEvent) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*SetRequest)(nil), "server.SetRequest")
 	proto.RegisterType((*SetResponse)(nil), "server.SetResponse")
@@ -200,6 +416,14 @@ func init() {
 	proto.RegisterType((*DelResponse)(nil), "server.DelResponse")
 	proto.RegisterType((*JoinRequest)(nil), "server.JoinRequest")
 	proto.RegisterType((*JoinResponse)(nil), "server.JoinResponse")
+	proto.RegisterType((*BackupRequest)(nil), "server.BackupRequest")
+	proto.RegisterType((*BackupChunk)(nil), "server.BackupChunk")
+	proto.RegisterType((*ImportChunk)(nil), "server.ImportChunk")
+	proto.RegisterType((*ImportResponse)(nil), "server.ImportResponse")
+	proto.RegisterType((*WatchRequest)(nil), "server.WatchRequest")
+	proto.RegisterType((*WatchEvent)(nil), "server.WatchEvent")
+	proto.RegisterType((*SubscribeRequest)(nil), "server.SubscribeRequest")
+	proto.RegisterType((*ChangeEvent)(nil), "server.ChangeEvent")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -217,6 +441,11 @@ type KVClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Del(ctx context.Context, in *DelRequest, opts ...grpc.CallOption) (*DelResponse, error)
 	Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error)
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (KV_BackupClient, error)
+	Import(ctx context.Context, opts ...grpc.CallOption) (KV_ImportClient, error)
+	Ingest(ctx context.Context, opts ...grpc.CallOption) (KV_IngestClient, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (KV_WatchClient, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (KV_SubscribeClient, error)
 }
 
 type kVClient struct {
@@ -263,6 +492,170 @@ func (c *kVClient) Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *kVClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (KV_BackupClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KV_serviceDesc.Streams[0], c.cc, "/server.KV/Backup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVBackupClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KV_BackupClient interface {
+	Recv() (*BackupChunk, error)
+	grpc.ClientStream
+}
+
+type kVBackupClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVBackupClient) Recv() (*BackupChunk, error) {
+	m := new(BackupChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVClient) Import(ctx context.Context, opts ...grpc.CallOption) (KV_ImportClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KV_serviceDesc.Streams[1], c.cc, "/server.KV/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVImportClient{stream}
+	return x, nil
+}
+
+type KV_ImportClient interface {
+	Send(*ImportChunk) error
+	CloseAndRecv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type kVImportClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVImportClient) Send(m *ImportChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kVImportClient) CloseAndRecv() (*ImportResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVClient) Ingest(ctx context.Context, opts ...grpc.CallOption) (KV_IngestClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KV_serviceDesc.Streams[2], c.cc, "/server.KV/Ingest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVIngestClient{stream}
+	return x, nil
+}
+
+type KV_IngestClient interface {
+	Send(*ImportChunk) error
+	CloseAndRecv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type kVIngestClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVIngestClient) Send(m *ImportChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kVIngestClient) CloseAndRecv() (*ImportResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (KV_WatchClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KV_serviceDesc.Streams[3], c.cc, "/server.KV/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KV_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type kVWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (KV_SubscribeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KV_serviceDesc.Streams[4], c.cc, "/server.KV/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KV_SubscribeClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type kVSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVSubscribeClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for KV service
 
 type KVServer interface {
@@ -270,6 +663,11 @@ type KVServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Del(context.Context, *DelRequest) (*DelResponse, error)
 	Join(context.Context, *JoinRequest) (*JoinResponse, error)
+	Backup(*BackupRequest, KV_BackupServer) error
+	Import(KV_ImportServer) error
+	Ingest(KV_IngestServer) error
+	Watch(*WatchRequest, KV_WatchServer) error
+	Subscribe(*SubscribeRequest, KV_SubscribeServer) error
 }
 
 func RegisterKVServer(s *grpc.Server, srv KVServer) {
@@ -348,6 +746,121 @@ func _KV_Join_Handler(srv interface{}, ctx context.Context, dec func(interface{}
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KV_Backup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVServer).Backup(m, &kVBackupServer{stream})
+}
+
+type KV_BackupServer interface {
+	Send(*BackupChunk) error
+	grpc.ServerStream
+}
+
+type kVBackupServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVBackupServer) Send(m *BackupChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KV_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVServer).Import(&kVImportServer{stream})
+}
+
+type KV_ImportServer interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ImportChunk, error)
+	grpc.ServerStream
+}
+
+type kVImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVImportServer) SendAndClose(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kVImportServer) Recv() (*ImportChunk, error) {
+	m := new(ImportChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KV_Ingest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVServer).Ingest(&kVIngestServer{stream})
+}
+
+type KV_IngestServer interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ImportChunk, error)
+	grpc.ServerStream
+}
+
+type kVIngestServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVIngestServer) SendAndClose(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kVIngestServer) Recv() (*ImportChunk, error) {
+	m := new(ImportChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KV_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVServer).Watch(m, &kVWatchServer{stream})
+}
+
+type KV_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type kVWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KV_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVServer).Subscribe(m, &kVSubscribeServer{stream})
+}
+
+type KV_SubscribeServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type kVSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVSubscribeServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _KV_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "server.KV",
 	HandlerType: (*KVServer)(nil),
@@ -369,29 +882,75 @@ var _KV_serviceDesc = grpc.ServiceDesc{
 			Handler:    _KV_Join_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Backup",
+			Handler:       _KV_Backup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _KV_Import_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Ingest",
+			Handler:       _KV_Ingest_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _KV_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _KV_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "kv.proto",
 }
 
 func init() { proto.RegisterFile("kv.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 272 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x92, 0x4f, 0x4b, 0xc3, 0x40,
-	0x10, 0xc5, 0xfb, 0xcf, 0x50, 0x5f, 0x3c, 0xc8, 0x58, 0x4a, 0xe9, 0xa1, 0xc8, 0x16, 0xc4, 0x53,
-	0x10, 0xeb, 0xa5, 0xf7, 0xc0, 0xa2, 0xde, 0x52, 0xf0, 0x5e, 0xc9, 0x1c, 0x62, 0x43, 0xb6, 0x66,
-	0xb7, 0x41, 0x3f, 0xa5, 0x5f, 0x49, 0xb2, 0xbb, 0x35, 0xa9, 0xd8, 0x7a, 0x9b, 0x37, 0x93, 0xdf,
-	0x7b, 0xc9, 0x23, 0x18, 0x6e, 0xaa, 0x68, 0x5b, 0x2a, 0xa3, 0x28, 0xd0, 0x5c, 0x56, 0x5c, 0x8a,
-	0x07, 0x60, 0xc5, 0x26, 0xe1, 0xf7, 0x1d, 0x6b, 0x43, 0x97, 0xe8, 0x6f, 0xf8, 0x73, 0xd2, 0xbd,
-	0xee, 0xde, 0x9e, 0x27, 0xf5, 0x48, 0x23, 0x9c, 0x55, 0xeb, 0x7c, 0xc7, 0x93, 0x9e, 0xdd, 0x39,
-	0x21, 0xe6, 0x08, 0x2d, 0xa5, 0xb7, 0xaa, 0xd0, 0x5c, 0x3f, 0xc4, 0x1f, 0x99, 0x36, 0x16, 0x1c,
-	0x26, 0x4e, 0x88, 0x19, 0x20, 0x4f, 0x58, 0x8b, 0x25, 0x42, 0xf9, 0x9f, 0xc9, 0x91, 0xfc, 0x19,
-	0x10, 0x73, 0x7e, 0xdc, 0x7a, 0x8e, 0xd0, 0xde, 0x4f, 0xbe, 0xdf, 0x12, 0xe1, 0x93, 0xca, 0x8a,
-	0xbd, 0x0b, 0x61, 0xb0, 0x4e, 0xd3, 0xd2, 0xdb, 0xd8, 0x99, 0xc6, 0x08, 0x0a, 0x95, 0xf2, 0x63,
-	0xec, 0xe3, 0xbd, 0x12, 0x37, 0xb8, 0x70, 0xa8, 0x0f, 0x18, 0x23, 0x78, 0x53, 0x59, 0xc1, 0xa9,
-	0x4f, 0xf0, 0xea, 0xfe, 0xab, 0x8b, 0xde, 0xf3, 0x0b, 0xdd, 0xa1, 0xbf, 0x62, 0x43, 0x14, 0xb9,
-	0xd2, 0xa3, 0xa6, 0xf1, 0xe9, 0xd5, 0xc1, 0xce, 0xd9, 0x89, 0x4e, 0x4d, 0xc8, 0x36, 0x21, 0xff,
-	0x20, 0xe4, 0x6f, 0x22, 0xe6, 0xbc, 0x21, 0x9a, 0x7e, 0x1a, 0xa2, 0xd5, 0x89, 0xe8, 0xd0, 0x02,
-	0x83, 0xfa, 0x23, 0xe8, 0xe7, 0xdc, 0x6a, 0x63, 0x3a, 0x3a, 0x5c, 0xee, 0xa1, 0xd7, 0xc0, 0xfe,
-	0x3e, 0x8b, 0xef, 0x00, 0x00, 0x00, 0xff, 0xff, 0xf3, 0x93, 0x8a, 0x54, 0x4a, 0x02, 0x00, 0x00,
+	// 585 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0x6d, 0xec, 0xc4, 0x4a, 0xc6, 0xa5, 0xaa, 0xb6, 0x25, 0x8a, 0x0c, 0x54, 0xb0, 0x15, 0xa8,
+	0x07, 0x14, 0x15, 0x0a, 0x12, 0xb9, 0x70, 0xa0, 0xa9, 0xa2, 0xc0, 0xcd, 0x41, 0xe5, 0x46, 0xe5,
+	0xc4, 0x93, 0xc6, 0x24, 0xf1, 0x1a, 0xef, 0x26, 0x4a, 0xfe, 0x38, 0x67, 0xb4, 0x1f, 0xfe, 0x8a,
+	0xd2, 0x22, 0x71, 0xdb, 0x79, 0x9e, 0x37, 0xf3, 0x66, 0xf7, 0x8d, 0xa1, 0x39, 0x5f, 0x77, 0x93,
+	0x94, 0x09, 0x46, 0x1c, 0x8e, 0xe9, 0x1a, 0x53, 0xfa, 0x01, 0x60, 0x84, 0xc2, 0xc7, 0xdf, 0x2b,
+	0xe4, 0x82, 0x1c, 0x83, 0x3d, 0xc7, 0x6d, 0xa7, 0xf6, 0xb2, 0x76, 0xd1, 0xf2, 0xe5, 0x91, 0x9c,
+	0x42, 0x63, 0x1d, 0x2c, 0x56, 0xd8, 0xb1, 0x14, 0xa6, 0x03, 0x7a, 0x0e, 0xae, 0x62, 0xf1, 0x84,
+	0xc5, 0x1c, 0x65, 0x12, 0x6e, 0x22, 0x2e, 0x14, 0xb1, 0xe9, 0xeb, 0x80, 0x9e, 0x01, 0x0c, 0x1e,
+	0x29, 0x4d, 0x7b, 0xe0, 0x0e, 0xfe, 0x55, 0xe4, 0x81, 0xfe, 0x67, 0x00, 0x7d, 0x5c, 0x3c, 0x5c,
+	0xfa, 0x1c, 0x5c, 0xf5, 0xfd, 0x51, 0x7d, 0x3d, 0x70, 0xbf, 0xb2, 0x28, 0xce, 0xaa, 0x10, 0xa8,
+	0x07, 0x61, 0x98, 0x9a, 0x32, 0xea, 0x4c, 0xda, 0xe0, 0xc4, 0x2c, 0xc4, 0x61, 0xdf, 0xb4, 0x37,
+	0x11, 0x7d, 0x03, 0x87, 0x9a, 0x6a, 0x1a, 0xb4, 0xc1, 0xf9, 0xc5, 0xa2, 0x18, 0x43, 0xd3, 0xc1,
+	0x44, 0xf4, 0x35, 0x3c, 0xf9, 0x12, 0x4c, 0xe6, 0xab, 0x24, 0x6b, 0x72, 0x0a, 0x0d, 0x1e, 0xc5,
+	0x13, 0x54, 0x79, 0x87, 0xbe, 0x0e, 0xe8, 0x2b, 0x70, 0x75, 0xda, 0xf5, 0x6c, 0x15, 0xcf, 0xa5,
+	0x92, 0x30, 0x10, 0x81, 0xc9, 0x51, 0x67, 0x7a, 0x0b, 0xee, 0x70, 0x99, 0xb0, 0x54, 0xe8, 0x94,
+	0x36, 0x38, 0x53, 0x96, 0x2e, 0x03, 0x61, 0xe4, 0x9a, 0x28, 0xa7, 0x5a, 0x05, 0x95, 0x3c, 0x83,
+	0x56, 0x98, 0xb2, 0xe4, 0x4e, 0x88, 0x05, 0xef, 0xd8, 0x4a, 0x5f, 0x53, 0x02, 0xdf, 0xc5, 0x82,
+	0xd3, 0xb7, 0x70, 0xa4, 0xeb, 0xe6, 0xb3, 0x78, 0xd0, 0x8c, 0x14, 0x62, 0xa6, 0xb1, 0xfd, 0x3c,
+	0xa6, 0x37, 0x70, 0xf8, 0x23, 0x10, 0x93, 0x59, 0x36, 0x4e, 0x1b, 0x9c, 0x24, 0xc5, 0x69, 0xb4,
+	0xc9, 0x64, 0xe8, 0x88, 0xbc, 0x00, 0x98, 0xa6, 0x6c, 0x79, 0x17, 0xc5, 0x21, 0x6e, 0x94, 0x98,
+	0xba, 0xdf, 0x92, 0xc8, 0x50, 0x02, 0xf4, 0x27, 0x80, 0x2a, 0x73, 0xb3, 0xc6, 0x58, 0x69, 0x16,
+	0xdb, 0x04, 0xb3, 0x8b, 0x97, 0xe7, 0xec, 0x49, 0xad, 0x3d, 0x46, 0xb4, 0x4b, 0x46, 0x90, 0xa8,
+	0xee, 0x51, 0x57, 0x3d, 0x74, 0x40, 0xdf, 0xc1, 0xf1, 0x68, 0x35, 0xe6, 0x93, 0x34, 0x1a, 0x63,
+	0x26, 0xb5, 0x2a, 0xa9, 0xb6, 0x2b, 0x69, 0x0b, 0xee, 0xf5, 0x2c, 0x88, 0xef, 0x51, 0x6b, 0xca,
+	0xeb, 0xd6, 0x4a, 0x75, 0xc9, 0x11, 0x58, 0x2c, 0x31, 0xa2, 0x2c, 0x96, 0x64, 0x2a, 0xed, 0x3d,
+	0x2a, 0xeb, 0x65, 0x95, 0xcf, 0xa1, 0x25, 0xa2, 0x25, 0x72, 0x11, 0x2c, 0x93, 0x4e, 0x43, 0xdd,
+	0x69, 0x01, 0xbc, 0xff, 0x63, 0x83, 0xf5, 0xed, 0x96, 0x5c, 0x82, 0x3d, 0x42, 0x41, 0x48, 0x57,
+	0x6f, 0x66, 0xb7, 0x58, 0x4b, 0xef, 0xa4, 0x82, 0xe9, 0x77, 0xa2, 0x07, 0x92, 0x31, 0x28, 0x33,
+	0x06, 0x7b, 0x18, 0x83, 0x5d, 0x46, 0x1f, 0x17, 0x05, 0xa3, 0x58, 0x22, 0xef, 0xa4, 0x82, 0xe5,
+	0x8c, 0x2b, 0xa8, 0x4b, 0xa7, 0x93, 0xfc, 0x73, 0x69, 0x65, 0xbc, 0xd3, 0x2a, 0x98, 0x93, 0x3e,
+	0x81, 0xa3, 0xfd, 0x4c, 0x9e, 0x66, 0x19, 0x95, 0x35, 0xf0, 0x4e, 0xaa, 0xb0, 0xf2, 0x34, 0x3d,
+	0xb8, 0xac, 0x91, 0x1e, 0x38, 0xda, 0x8e, 0x45, 0xc3, 0x92, 0xed, 0xbd, 0x76, 0x15, 0x2c, 0x5a,
+	0x5e, 0x68, 0x6a, 0x7c, 0x8f, 0xfc, 0x3f, 0xa8, 0x1f, 0xa1, 0xa1, 0xfc, 0x48, 0xf2, 0x81, 0xca,
+	0x2e, 0xf7, 0x48, 0x05, 0x55, 0x06, 0x51, 0x62, 0x3f, 0x43, 0x2b, 0xb7, 0x19, 0xe9, 0xe4, 0x6f,
+	0xb4, 0xe3, 0xbc, 0x62, 0xd8, 0x92, 0xc1, 0x24, 0x7f, 0xec, 0xa8, 0x5f, 0xf1, 0xd5, 0xdf, 0x01,
+	0x00, 0xe4, 0x4d, 0x6c, 0xfc, 0x96, 0x05, 0x00, 0x00,
 }
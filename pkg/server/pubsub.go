@@ -0,0 +1,329 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+// Keyspace notifications work as in Redis: a change to a key is published to
+// the channel __keyspace@0__:<key> with the command as message, and to the
+// channel __keyevent@0__:<command> with the key as message. The commands are
+// set and del.
+const (
+	keyspaceChannel = "__keyspace@0__:"
+	keyeventChannel = "__keyevent@0__:"
+)
+
+// subscription holds the channels and patterns a Redis connection subscribed
+// to and sends it the keyspace notifications that match them. Every write to
+// the connection has timeout to complete; a write that fails or times out,
+// as it does for a client that stopped reading, ends the subscription and
+// closes the connection.
+type subscription struct {
+	conn    net.Conn
+	store   *Store
+	timeout time.Duration
+
+	lock     sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newSubscription starts sending notifications to conn. A timeout of 0 is
+// taken as DefaultCommandTimeout: notifications are not held up forever even
+// when commands may be.
+func newSubscription(store *Store, conn net.Conn, timeout time.Duration) *subscription {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	s := &subscription{
+		conn:     conn,
+		store:    store,
+		timeout:  timeout,
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// run sends notifications for the changes applied to the store until the
+// subscription is closed.
+func (s *subscription) run() {
+	defer close(s.done)
+
+	for {
+		events, cancel, err := s.store.Watch("", 0)
+		if err != nil {
+			log.Error("Error: ", err)
+			return
+		}
+
+		overflow := s.notify(events)
+		cancel()
+
+		// Notifications are not guaranteed to be delivered in Redis either,
+		// so a subscriber that fell behind just misses some.
+		if !overflow {
+			return
+		}
+		log.Warn("Subscriber ", s.conn.RemoteAddr(), " fell behind, notifications were dropped")
+	}
+}
+
+// notify sends notifications for events until the subscription is closed,
+// events is or a notification cannot be written, and reports whether it
+// ended because the watcher fell behind.
+func (s *subscription) notify(events <-chan *WatchEvent) bool {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			if e.Type == kv.EventOverflow.String() {
+				return true
+			}
+
+			command := "set"
+			if e.Type == kv.EventDelete.String() {
+				command = "del"
+			}
+
+			if err := s.publish(keyspaceChannel+e.Key, command); err != nil {
+				log.Warn("Dropping subscriber ", s.conn.RemoteAddr(), ": ", err)
+				return false
+			}
+			if err := s.publish(keyeventChannel+command, e.Key); err != nil {
+				log.Warn("Dropping subscriber ", s.conn.RemoteAddr(), ": ", err)
+				return false
+			}
+		case <-s.stop:
+			return false
+		}
+	}
+}
+
+// publish sends message to the connection if it subscribed to channel or to
+// a pattern matching it.
+func (s *subscription) publish(channel, message string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.channels[channel] {
+		if err := s.write(fmt.Sprintf("*3\r\n%s%s%s", bulkString("message"), bulkString(channel), bulkString(message))); err != nil {
+			return err
+		}
+	}
+
+	for pattern := range s.patterns {
+		if globMatch(pattern, channel) {
+			if err := s.write(fmt.Sprintf("*4\r\n%s%s%s%s", bulkString("pmessage"), bulkString(pattern), bulkString(channel), bulkString(message))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// write sends reply to the connection, giving up after timeout. The
+// connection is closed if that fails, which also ends handleClient. The
+// caller must hold lock.
+func (s *subscription) write(reply string) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err := s.conn.Write([]byte(reply))
+	s.conn.SetWriteDeadline(time.Time{})
+
+	if err != nil {
+		s.conn.Close()
+	}
+
+	return err
+}
+
+// subscribe handles SUBSCRIBE and PSUBSCRIBE, adding names to the channels or
+// patterns depending on kind.
+func (s *subscription) subscribe(kind string, names []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := s.channels
+	if kind == "psubscribe" {
+		set = s.patterns
+	}
+
+	for _, name := range names {
+		set[name] = true
+		s.reply(kind, name)
+	}
+}
+
+// unsubscribe handles UNSUBSCRIBE and PUNSUBSCRIBE, removing names, or all
+// of them if there are none, from the channels or patterns depending on kind.
+func (s *subscription) unsubscribe(kind string, names []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := s.channels
+	if kind == "punsubscribe" {
+		set = s.patterns
+	}
+
+	if len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			s.write(fmt.Sprintf("*3\r\n%s$-1\r\n:%d\r\n", bulkString(kind), s.count()))
+			return
+		}
+	}
+
+	for _, name := range names {
+		delete(set, name)
+		s.reply(kind, name)
+	}
+}
+
+// pong replies to PING the way Redis does for subscribed connections.
+func (s *subscription) pong() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.write(fmt.Sprintf("*2\r\n%s%s", bulkString("pong"), bulkString("")))
+}
+
+// rejects reports whether command has to be refused because the connection
+// is subscribed to channels or patterns, in which case, as in Redis, only the
+// commands that change the subscription and PING are allowed. It replies
+// with the error itself. It can be called on a nil subscription.
+func (s *subscription) rejects(command string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.count() == 0 {
+		return false
+	}
+
+	s.write(fmt.Sprintf("-ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n", strings.ToLower(command)))
+
+	return true
+}
+
+// reply confirms a change of the subscription. The caller must hold lock.
+func (s *subscription) reply(kind, name string) {
+	s.write(fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulkString(kind), bulkString(name), s.count()))
+}
+
+// count returns the number of channels and patterns subscribed to. The
+// caller must hold lock.
+func (s *subscription) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// close stops the notifications. The connection is closed first, so that a
+// notification being written to a client that stopped reading does not hold
+// it up.
+func (s *subscription) close() {
+	close(s.stop)
+	s.conn.Close()
+	<-s.done
+}
+
+func bulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// globMatch reports whether s matches the Redis glob-style pattern: * matches
+// any string, ? any character, [...] any of a set of characters, which can
+// hold ranges and be negated with ^, and \ escapes the next character.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 || len(s) == 0 {
+				return false
+			}
+			if !matchClass(pattern[1:end+1], s[0]) {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// matchClass reports whether c is in the character class of a [...] pattern.
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	match := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				match = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			match = true
+		}
+	}
+
+	return match != negate
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"__keyspace@0__:*", "__keyspace@0__:user:1", true},
+		{"__keyspace@0__:*", "__keyevent@0__:set", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:10", false},
+		{"user:[0-4]", "user:3", true},
+		{"user:[^0-4]", "user:3", false},
+		{"user:[ab]*", "user:b12", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+	}
+
+	for _, test := range tests {
+		if match := globMatch(test.pattern, test.s); match != test.match {
+			t.Errorf("Expected `%v` for `%s` and `%s`. Got `%v`\n", test.match, test.pattern, test.s, match)
+		}
+	}
+}
+
+func TestServerKeyspaceNotifications(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12006", true)
+
+	go func() {
+		ListenAndServ(":56380", store)
+	}()
+
+	time.Sleep(3 * time.Second)
+
+	client := redis.NewClient(&redis.Options{Addr: "localhost:56380"})
+	defer client.Close()
+
+	pubsub := client.PSubscribe("__keyspace@0__:user:*")
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	if err := pubsub.Subscribe("__keyevent@0__:del"); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	store.Set("user:1", "alice")
+	store.Set("session:1", "x")
+	store.Delete("user:1")
+
+	expected := []redis.Message{
+		{Channel: "__keyspace@0__:user:1", Pattern: "__keyspace@0__:user:*", Payload: "set"},
+		{Channel: "__keyspace@0__:user:1", Pattern: "__keyspace@0__:user:*", Payload: "del"},
+		{Channel: "__keyevent@0__:del", Payload: "user:1"},
+	}
+
+	for _, e := range expected {
+		msg, err := pubsub.ReceiveTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+
+		if m, ok := msg.(*redis.Message); !ok || *m != e {
+			t.Errorf("Expected `%v`. Got `%v`\n", e, msg)
+		}
+	}
+
+	// Like Redis, a subscribed connection refuses other commands.
+	conn, err := net.Dial("tcp", "localhost:56380")
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$1\r\nx\r\n"))
+	for i := 0; i < 6; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+	}
+
+	conn.Write([]byte("*2\r\n$3\r\nGET\r\n$6\r\nuser:1\r\n"))
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "-ERR Can't execute 'get'") {
+		t.Errorf("Expected `-ERR Can't execute 'get'...`. Got `%v`\n", line)
+	}
+}
+
+func TestSubscriptionStalledClient(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12011", true)
+	defer store.Close()
+
+	time.Sleep(3 * time.Second)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	sub := newSubscription(store, server, 100*time.Millisecond)
+
+	go sub.subscribe("subscribe", []string{"__keyevent@0__:set"})
+	r := bufio.NewReader(client)
+	for i := 0; i < 6; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatalf("Expected `nil`. Got `%v`\n", err)
+		}
+	}
+
+	// The client stops reading, so the notification cannot be written and
+	// the subscription ends, closing the connection.
+	store.Set("key", "value")
+
+	time.Sleep(time.Second)
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Errorf("Expected the connection to be closed\n")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		sub.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the subscription to close\n")
+	}
+}
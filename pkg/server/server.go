@@ -2,20 +2,34 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultCommandTimeout is how long ListenAndServ gives a command to be
+// applied and its reply to be written.
+const DefaultCommandTimeout = 10 * time.Second
+
 // ListenAndServ accepts incoming connections on the creating a new service goroutine for each.
 // The service goroutines read requests and then replies to them.
 // It exits program if it can not start tcp listener.
 func ListenAndServ(port string, store *Store) {
+	ListenAndServWithTimeout(port, store, DefaultCommandTimeout)
+}
+
+// ListenAndServWithTimeout is ListenAndServ giving every command timeout to
+// be applied through the Raft log and to have its reply written. A timeout of
+// 0 lets commands wait as long as they need.
+func ListenAndServWithTimeout(port string, store *Store, timeout time.Duration) {
 	sigs := make(chan os.Signal, 1)
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -28,7 +42,9 @@ func ListenAndServ(port string, store *Store) {
 
 		log.Info("Saving data on disk...")
 
-		store.Close()
+		if err := store.Close(); err != nil {
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}()
 
@@ -45,14 +61,21 @@ func ListenAndServ(port string, store *Store) {
 			log.Error("Fatal error: ", err.Error())
 			continue
 		}
-		go handleClient(store, conn)
+		go handleClient(store, conn, timeout)
 	}
 }
 
-func handleClient(store *Store, conn net.Conn) {
+func handleClient(store *Store, conn net.Conn, timeout time.Duration) {
 	request := make([]byte, 128)
 	defer conn.Close()
 
+	var sub *subscription
+	defer func() {
+		if sub != nil {
+			sub.close()
+		}
+	}()
+
 	for {
 		readLen, err := conn.Read(request)
 
@@ -70,13 +93,24 @@ func handleClient(store *Store, conn net.Conn) {
 
 			scanner.Scan()
 			op := scanner.Text()
+			argc, _ := strconv.Atoi(strings.TrimPrefix(op, "*"))
 
 			scanner.Scan()
 			scanner.Scan()
 			op = strings.ToUpper(scanner.Text())
 
+			ctx, cancel := commandContext(timeout)
+			// Subscriptions set their own deadline for every write.
+			if timeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(timeout))
+			}
+
 			switch op {
 			case "GET":
+				if sub.rejects(op) {
+					break
+				}
+
 				scanner.Scan()
 				scanner.Scan()
 				key := scanner.Text()
@@ -90,6 +124,10 @@ func handleClient(store *Store, conn net.Conn) {
 					conn.Write([]byte(fmt.Sprintf("$-1\r\n")))
 				}
 			case "SET":
+				if sub.rejects(op) {
+					break
+				}
+
 				scanner.Scan()
 				scanner.Scan()
 				key := scanner.Text()
@@ -98,32 +136,78 @@ func handleClient(store *Store, conn net.Conn) {
 				scanner.Scan()
 				value := scanner.Text()
 
-				err := store.Set(key, value)
+				err := store.SetContext(ctx, key, value)
 
 				if err == nil {
 					conn.Write([]byte(fmt.Sprintf("+OK\r\n")))
 				} else {
-					conn.Write([]byte(fmt.Sprintf("$-1\r\n")))
+					conn.Write([]byte(errorReply(err)))
 				}
 			case "DEL":
+				if sub.rejects(op) {
+					break
+				}
+
 				scanner.Scan()
 				scanner.Scan()
 				key := scanner.Text()
 
-				err := store.Delete(key)
+				err := store.DeleteContext(ctx, key)
 				if err == nil {
 					conn.Write([]byte(fmt.Sprintf(":1\r\n")))
 				} else {
-					conn.Write([]byte(fmt.Sprintf("$-1\r\n")))
+					conn.Write([]byte(errorReply(err)))
+				}
+
+			case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+				var names []string
+				for i := 1; i < argc; i++ {
+					scanner.Scan()
+					scanner.Scan()
+					names = append(names, scanner.Text())
+				}
+
+				if sub == nil {
+					sub = newSubscription(store, conn, timeout)
+				}
+
+				if op == "SUBSCRIBE" || op == "PSUBSCRIBE" {
+					sub.subscribe(strings.ToLower(op), names)
+				} else {
+					sub.unsubscribe(strings.ToLower(op), names)
+				}
+			case "PING":
+				if sub != nil {
+					sub.pong()
+				} else {
+					conn.Write([]byte("+PONG\r\n"))
 				}
 
 			default:
 				conn.Write([]byte(fmt.Sprintf("-ERR unknown command '%s'\r\n", op)))
 			}
+
+			cancel()
 		}
 	}
 }
 
+// errorReply returns the error reply for a command that failed with err.
+func errorReply(err error) string {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	return fmt.Sprintf("-ERR %s\r\n", msg)
+}
+
+// commandContext returns the context a command runs in, done after timeout
+// unless it is 0.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
 func checkError(err error) {
 	if err != nil {
 		log.Fatal("Fatal error: ", err.Error())
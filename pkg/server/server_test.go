@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/go-redis/redis"
+	kv "github.com/kgantsov/kvgo/pkg/kv"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,7 +23,10 @@ func TestServerBasic(t *testing.T) {
 	raftDir := filepath.Join(tmpDir, "raft")
 
 	log.Info("Creating storage...")
-	store := NewStore(dbPath, indexPath, 1000, 10000, raftDir, raftAddr)
+	store, err := NewStore(dbPath, indexPath, 1000, 10000, raftDir, raftAddr)
+	if err != nil {
+		log.Fatalf("failed to create store: %s", err.Error())
+	}
 
 	if err := store.Open(true, "node1"); err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
@@ -40,7 +44,7 @@ func TestServerBasic(t *testing.T) {
 		DB:       0,
 	})
 
-	err := client.Set("key", "value", 0).Err()
+	err = client.Set("key", "value", 0).Err()
 	if err != nil {
 		t.Errorf("Expected `nil`. Got `%v`\n", err)
 	}
@@ -71,3 +75,52 @@ func TestServerBasic(t *testing.T) {
 	}
 	client.Close()
 }
+
+func TestServerApplyError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "data.db")
+	indexPath := filepath.Join(tmpDir, "indexes.idx")
+
+	db, err := kv.OpenKV(dbPath, indexPath)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	db.Close()
+
+	// Every write the FSM applies to a read-only engine fails.
+	db, err = kv.OpenKV(dbPath, indexPath, kv.WithReadOnly())
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	store := NewStoreWithEngine(db, filepath.Join(tmpDir, "raft"), "127.0.0.1:12008")
+	if err := store.Open(true, "node1"); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	go func() {
+		ListenAndServ(":56381", store)
+	}()
+
+	time.Sleep(3 * time.Second)
+
+	if err := store.Set("key", "value"); err != kv.ErrReadOnly {
+		t.Errorf("Expected `%v`. Got `%v`\n", kv.ErrReadOnly, err)
+	}
+	if err := store.Delete("key"); err != kv.ErrReadOnly {
+		t.Errorf("Expected `%v`. Got `%v`\n", kv.ErrReadOnly, err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: "localhost:56381"})
+	defer client.Close()
+
+	expected := "ERR " + kv.ErrReadOnly.Error()
+	if err := client.Set("key", "value", 0).Err(); err == nil || err.Error() != expected {
+		t.Errorf("Expected `%s`. Got `%v`\n", expected, err)
+	}
+	if err := client.Del("key").Err(); err == nil || err.Error() != expected {
+		t.Errorf("Expected `%s`. Got `%v`\n", expected, err)
+	}
+}
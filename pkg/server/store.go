@@ -1,12 +1,18 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -19,25 +25,71 @@ type Store struct {
 	RaftDir  string
 	RaftBind string
 
-	KV *kv.KV
+	// Engine holds the data. It is a *kv.KV on disk for stores created with
+	// NewStore; SyncToDisk, Backup and CompactData need one.
+	Engine kv.Engine
 
-	raft *raft.Raft // The consensus mechanism
+	// KeyRing, when set, encrypts the commands stored in the Raft log and
+	// the snapshots taken of the store.
+	KeyRing *kv.KeyRing
+
+	raft      *raft.Raft // The consensus mechanism
+	logs      raft.LogStore
+	snapshots raft.SnapshotStore
+
+	watch watchHub
+
+	// ingest is held for writing by Ingest and for reading by everything
+	// that proposes writes, so that no write is applied during an ingest.
+	ingest sync.RWMutex
+	// staged is set while the leader restores the snapshot an ingest took of
+	// the files it staged, which Restore installs instead of reading the
+	// snapshot back.
+	staged     *kv.StagedIngest
+	stagedLock sync.Mutex
 }
 
 const (
 	retainSnapshotCount = 2
 	raftTimeout         = 10 * time.Second
+
+	// DefaultImportBatchSize is the number of entries Import applies per
+	// Raft log entry.
+	DefaultImportBatchSize = 1000
 )
 
 type command struct {
-	Op    string `json:"op,omitempty"`
-	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
+	Op    string    `json:"op,omitempty"`
+	Key   string    `json:"key,omitempty"`
+	Value string    `json:"value,omitempty"`
+	Batch []command `json:"batch,omitempty"`
+	// Time is when the leader proposed the command, in nanoseconds since
+	// the Unix epoch. Commands proposed before it was recorded have none.
+	Time int64 `json:"time,omitempty"`
+}
+
+// ops returns the sets and deletes c is made of.
+func (c *command) ops() []command {
+	if c.Op == "batch" {
+		return c.Batch
+	}
+
+	return []command{*c}
 }
 
-func NewStore(dbPath, indexPath string, blockSize uint32, maxBlockNumber int16, RaftDir, RaftBind string) *Store {
+func NewStore(dbPath, indexPath string, blockSize uint32, maxBlockNumber int16, RaftDir, RaftBind string, opts ...kv.Option) (*Store, error) {
+	db, err := kv.OpenKV(dbPath, indexPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStoreWithEngine(db, RaftDir, RaftBind), nil
+}
+
+// NewStoreWithEngine creates a store that keeps its data in engine.
+func NewStoreWithEngine(engine kv.Engine, RaftDir, RaftBind string) *Store {
 	store := new(Store)
-	store.KV = kv.NewKV(dbPath, indexPath, blockSize, maxBlockNumber)
+	store.Engine = engine
 
 	store.RaftDir = RaftDir
 	store.RaftBind = RaftBind
@@ -81,6 +133,8 @@ func (s *Store) Open(enableSingle bool, localID string) error {
 		return fmt.Errorf("new raft: %s", err)
 	}
 	s.raft = ra
+	s.logs = logStore
+	s.snapshots = snapshots
 
 	if enableSingle {
 		configuration := raft.Configuration{
@@ -99,9 +153,17 @@ func (s *Store) Open(enableSingle bool, localID string) error {
 	return nil
 }
 
+// Set sets key to value through the Raft log and waits for it to be applied;
+// see SetContext.
 func (s *Store) Set(key, value string) error {
+	return s.SetContext(context.Background(), key, value)
+}
+
+// SetContext sets key to value through the Raft log and waits for it to be
+// applied until ctx is done. A set given up on may still be applied later.
+func (s *Store) SetContext(ctx context.Context, key, value string) error {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+		return raft.ErrNotLeader
 	}
 
 	c := &command{
@@ -109,17 +171,17 @@ func (s *Store) Set(key, value string) error {
 		Key:   key,
 		Value: value,
 	}
-	b, err := json.Marshal(c)
+	b, err := s.encodeCommand(c)
 	if err != nil {
 		return err
 	}
 
-	s.raft.Apply(b, raftTimeout)
-	return nil
+	_, err = s.apply(ctx, b)
+	return err
 }
 
 func (s *Store) Get(key string) (string, error) {
-	val, ok := s.KV.Get(key)
+	val, ok := s.Engine.Get(key)
 	if ok {
 		return val, nil
 	}
@@ -127,33 +189,304 @@ func (s *Store) Get(key string) (string, error) {
 	return "", fmt.Errorf("Key doesn't exist")
 }
 
+// Delete deletes key through the Raft log and waits for it to be applied;
+// see DeleteContext.
 func (s *Store) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key through the Raft log and waits for it to be
+// applied until ctx is done. A delete given up on may still be applied later.
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+		return raft.ErrNotLeader
 	}
 
 	c := &command{
 		Op:  "delete",
 		Key: key,
 	}
-	b, err := json.Marshal(c)
+	b, err := s.encodeCommand(c)
 	if err != nil {
 		return err
 	}
 
-	s.raft.Apply(b, raftTimeout)
+	_, err = s.apply(ctx, b)
+	return err
+}
+
+// apply appends the command b to the Raft log, waits until it is applied or
+// ctx is done, and returns what FSM.Apply returned for it. An error returned
+// by FSM.Apply is returned as the error. The deadline of ctx, or raftTimeout
+// if it has none, also bounds the wait for the log to take the command.
+func (s *Store) apply(ctx context.Context, b []byte) (interface{}, error) {
+	s.ingest.RLock()
+	defer s.ingest.RUnlock()
+
+	timeout, err := raftTimeoutFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f := s.raft.Apply(b, timeout)
 
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Error()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	resp := f.Response()
+	if err, ok := resp.(error); ok {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// raftTimeoutFor returns how long a Raft operation made for ctx may wait:
+// until the deadline of ctx, or raftTimeout if it has none.
+func raftTimeoutFor(ctx context.Context) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return raftTimeout, nil
+	}
+
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, context.DeadlineExceeded
+	}
+
+	return timeout, nil
+}
+
+// Import sets every entry of the dump read from r through the Raft log, so
+// that they are replicated, batchSize entries per log entry. It returns the
+// number of entries applied.
+func (s *Store) Import(r io.Reader, format kv.DumpFormat, batchSize int, opts ...kv.DumpOption) (int, error) {
+	return s.ImportContext(context.Background(), r, format, batchSize, opts...)
+}
+
+// ImportContext is Import, stopping with the error of ctx once it is done.
+// Batches applied by then are kept.
+func (s *Store) ImportContext(ctx context.Context, r io.Reader, format kv.DumpFormat, batchSize int, opts ...kv.DumpOption) (int, error) {
+	if s.raft.State() != raft.Leader {
+		return 0, raft.ErrNotLeader
+	}
+
+	if batchSize < 1 {
+		batchSize = DefaultImportBatchSize
+	}
+
+	d := kv.NewDumpReader(r, format, opts...)
+	batch := make([]command, 0, batchSize)
+	n := 0
+
+	apply := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		b, err := s.encodeCommand(&command{Op: "batch", Batch: batch})
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.apply(ctx, b); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for {
+		key, value, err := d.Next()
+		if err == io.EOF {
+			err = apply()
+			return n, err
+		}
+		if err != nil {
+			return n, err
+		}
+
+		batch = append(batch, command{Op: "set", Key: key, Value: value})
+		if len(batch) == batchSize {
+			if err := apply(); err != nil {
+				return n, err
+			}
+		}
+	}
+}
+
+// Ingest loads the dump read from r into the store without applying every
+// entry through the Raft log the way Import does, which makes it much faster
+// for seeding a new cluster. It returns the number of entries loaded.
+//
+// The leader stages the dump with kv.KV.StageIngest, which writes new data
+// and index files for its engine, and restores a snapshot of them into Raft,
+// which the followers install to catch up. The snapshot is streamed from the
+// staged files, and the leader only switches to them once Raft restored it;
+// if the restore fails the store is left as it was. Writes wait until Ingest
+// is done, so none of them are lost. Engines without files import the dump
+// through the Raft log instead.
+func (s *Store) Ingest(r io.Reader, format kv.DumpFormat, opts ...kv.DumpOption) (int, error) {
+	return s.IngestContext(context.Background(), r, format, opts...)
+}
+
+// IngestContext is Ingest, giving up with the error of ctx once it is done.
+func (s *Store) IngestContext(ctx context.Context, r io.Reader, format kv.DumpFormat, opts ...kv.DumpOption) (int, error) {
+	if s.raft.State() != raft.Leader {
+		return 0, raft.ErrNotLeader
+	}
+
+	db, ok := s.Engine.(*kv.KV)
+	if ok {
+		n, err := s.ingestFiles(ctx, db, r, format, opts...)
+		if !errors.Is(err, kv.ErrInMemory) {
+			return n, err
+		}
+	}
+
+	return s.ImportContext(ctx, r, format, DefaultImportBatchSize, opts...)
+}
+
+func (s *Store) ingestFiles(ctx context.Context, db *kv.KV, r io.Reader, format kv.DumpFormat, opts ...kv.DumpOption) (int, error) {
+	s.ingest.Lock()
+	defer s.ingest.Unlock()
+
+	timeout, err := raftTimeoutFor(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Everything committed before the ingest has to be in the engine, or
+	// the snapshot would leave it out.
+	if err := s.raft.Barrier(timeout).Error(); err != nil {
+		return 0, err
+	}
+
+	staged, err := db.StageIngestContext(ctx, r, format, opts...)
+	if err != nil {
+		return 0, err
+	}
+	// Unless Restore installed them, the staged files are dropped.
+	defer staged.Abort()
+
+	iterate := func(fn func(key, value string) error) error {
+		var err error
+		iterErr := staged.Iterate(func(key, value string) bool {
+			if err = ctx.Err(); err == nil {
+				err = fn(key, value)
+			}
+			return err == nil
+		})
+		if iterErr != nil {
+			return iterErr
+		}
+		return err
+	}
+
+	// Raft needs the size of the snapshot up front, so it is written twice:
+	// once to count its bytes, and once to Raft.
+	size := &countingWriter{}
+	if err := writeSnapshot(size, s.KeyRing, iterate); err != nil {
+		return 0, err
+	}
+
+	if timeout, err = raftTimeoutFor(ctx); err != nil {
+		return 0, err
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		pw.CloseWithError(writeSnapshot(pw, s.KeyRing, iterate))
+	}()
+	// Stops the goroutine above if Restore ended early, before the staged
+	// files it reads are dropped.
+	defer func() {
+		pr.Close()
+		<-written
+	}()
+
+	// Restore leaves a gap in the Raft log after the snapshot, so followers
+	// can only catch up by installing it.
+	s.setStaged(staged)
+	defer s.setStaged(nil)
+
+	meta := &raft.SnapshotMeta{Version: raft.SnapshotVersionMax, Size: size.n}
+	if err := s.raft.Restore(meta, pr, timeout); err != nil {
+		return 0, err
+	}
+
+	return staged.Ingested(), nil
+}
+
+func (s *Store) setStaged(staged *kv.StagedIngest) {
+	s.stagedLock.Lock()
+	s.staged = staged
+	s.stagedLock.Unlock()
+}
+
+// takeStaged returns the staged ingest, if any, and clears it.
+func (s *Store) takeStaged() *kv.StagedIngest {
+	s.stagedLock.Lock()
+	defer s.stagedLock.Unlock()
+
+	staged := s.staged
+	s.staged = nil
+
+	return staged
+}
+
+// Export writes the keys and values of the local copy of the store to w.
+func (s *Store) Export(w io.Writer, format kv.DumpFormat) (int, error) {
+	return s.ExportContext(context.Background(), w, format)
+}
+
+// ExportContext is Export, giving up with the error of ctx once it is done.
+func (s *Store) ExportContext(ctx context.Context, w io.Writer, format kv.DumpFormat) (int, error) {
+	return kv.ExportEngineContext(ctx, s.Engine, w, format)
+}
+
+func (s *Store) encodeCommand(c *command) ([]byte, error) {
+	c.Time = time.Now().UnixNano()
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.KeyRing != nil {
+		return s.KeyRing.Encrypt(b)
+	}
+
+	return b, nil
 }
 
 // Join joins a node, identified by nodeID and located at addr, to this store.
 // The node must be ready to respond to Raft communications at that address.
 func (s *Store) Join(nodeID, addr string) error {
-	log.Info("Rreceived join request for remote node %s at %s", nodeID, addr)
+	log.Infof("Rreceived join request for remote node %s at %s", nodeID, addr)
 
 	configFuture := s.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
-		log.Info("failed to get raft configuration: %v", err)
+		log.Infof("failed to get raft configuration: %v", err)
 		return err
 	}
 
@@ -164,7 +497,7 @@ func (s *Store) Join(nodeID, addr string) error {
 			// However if *both* the ID and the address are the same, then nothing -- not even
 			// a join operation -- is needed.
 			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(nodeID) {
-				log.Info("Node %s at %s already member of cluster, ignoring join request", nodeID, addr)
+				log.Infof("Node %s at %s already member of cluster, ignoring join request", nodeID, addr)
 				return nil
 			}
 
@@ -179,20 +512,49 @@ func (s *Store) Join(nodeID, addr string) error {
 	if f.Error() != nil {
 		return f.Error()
 	}
-	log.Info("Node %s at %s joined successfully", nodeID, addr)
+	log.Infof("Node %s at %s joined successfully", nodeID, addr)
 	return nil
 }
 
 func (s *Store) SyncToDisk() {
-	s.KV.SyncToDisk()
+	if db, ok := s.Engine.(*kv.KV); ok {
+		db.SyncToDisk()
+	}
+}
+
+// Backup writes a backup of the local copy of the store to w, incremental on
+// top of since unless it is nil.
+func (s *Store) Backup(w io.Writer, since *kv.BackupInfo) (*kv.BackupInfo, error) {
+	return s.BackupContext(context.Background(), w, since)
+}
+
+// BackupContext is Backup, giving up with the error of ctx once it is done.
+func (s *Store) BackupContext(ctx context.Context, w io.Writer, since *kv.BackupInfo) (*kv.BackupInfo, error) {
+	db, ok := s.Engine.(*kv.KV)
+	if !ok {
+		return nil, fmt.Errorf("backups are not supported by the %T engine", s.Engine)
+	}
+
+	return db.BackupSinceContext(ctx, w, since)
 }
 
 func (s *Store) CompactData() {
-	s.KV.CompactData()
+	if db, ok := s.Engine.(*kv.KV); ok {
+		db.CompactData()
+	}
 }
 
-func (s *Store) Close() {
-	s.KV.Close()
+// Close stops the watchers and closes the engine, returning its error if
+// writes were lost.
+func (s *Store) Close() error {
+	s.watch.closeAll()
+
+	if err := s.Engine.Close(); err != nil {
+		log.Error("Error closing the store: ", err)
+		return err
+	}
+
+	return nil
 }
 
 func (s *Store) Compacter() {
@@ -208,85 +570,246 @@ func (s *Store) Compacter() {
 
 type FSM Store
 
-// Apply applies a Raft log entry to the key-value store.
+// Apply applies a Raft log entry to the key-value store. It returns the error
+// of the engine if it failed to make the change, which the node that
+// proposed the entry returns to the caller.
 func (f *FSM) Apply(l *raft.Log) interface{} {
+	data, err := f.decrypt(l.Data)
+	if err != nil {
+		panic(fmt.Sprintf("failed to decrypt command: %s", err.Error()))
+	}
+
 	var c command
-	if err := json.Unmarshal(l.Data, &c); err != nil {
+	if err := json.Unmarshal(data, &c); err != nil {
 		panic(fmt.Sprintf("failed to unmarshal command: %s", err.Error()))
 	}
 
+	var resp interface{}
 	switch c.Op {
 	case "set":
-		return f.applySet(c.Key, c.Value)
+		resp = f.applySet(c.Key, c.Value)
 	case "delete":
-		return f.applyDelete(c.Key)
+		resp = f.applyDelete(c.Key)
+	case "batch":
+		resp = f.applyBatch(c.Batch)
 	default:
 		panic(fmt.Sprintf("unrecognized command op: %s", c.Op))
 	}
+
+	// A command the engine failed to apply changed nothing to watch, but
+	// change data capture has to skip it.
+	if _, failed := resp.(error); failed {
+		first, err := f.logs.FirstIndex()
+		if err != nil {
+			first = 0
+		}
+		f.watch.fail(l.Index, first)
+	} else {
+		f.watch.publish(l.Index, &c)
+	}
+
+	return resp
 }
 
 // Snapshot returns a snapshot of the key-value store.
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	f.KV.Lock.Lock()
-	defer f.KV.Lock.Unlock()
-
-	// Clone the map.
-	o := make(map[string]string)
-	for k, v := range f.KV.MemTable {
-		o[k] = v
+	items, err := f.Engine.Snapshot()
+	if err != nil {
+		return nil, err
 	}
-	return &fsmSnapshot{store: o}, nil
+
+	return &fsmSnapshot{store: items, keyRing: f.KeyRing}, nil
 }
 
 // Restore stores the key-value store to a previous state.
 func (f *FSM) Restore(rc io.ReadCloser) error {
-	o := make(map[string]string)
-	if err := json.NewDecoder(rc).Decode(&o); err != nil {
+	// The snapshot of an ingest was taken from the files this node staged,
+	// which hold the same entries. Should installing them fail, Raft stops
+	// the node, which then restores the snapshot when it starts again.
+	if staged := (*Store)(f).takeStaged(); staged != nil {
+		if err := staged.Install(); err != nil {
+			return err
+		}
+
+		f.watch.reset()
+		return nil
+	}
+
+	o, err := f.readSnapshot(rc)
+	if err != nil {
 		return err
 	}
 
+	// Watchers may miss changes the snapshot holds, so they have to start
+	// over.
+	f.watch.reset()
+
 	// Set the state from the snapshot, no lock required according to
 	// Hashicorp docs.
-	f.KV.MemTable = o
-	return nil
+	return f.Engine.Reset(o)
+}
+
+// readSnapshot reads the keys and values of a snapshot written by
+// writeSnapshot, or by Encrypt for snapshots taken before it streamed them.
+func (f *FSM) readSnapshot(r io.Reader) (map[string]string, error) {
+	br := bufio.NewReader(r)
+
+	if header, _ := br.Peek(4); kv.IsEncryptedStream(header) {
+		if f.KeyRing == nil {
+			return nil, fmt.Errorf("data is encrypted but no key ring is configured")
+		}
+		r = f.KeyRing.DecryptReader(br)
+	} else {
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if b, err = f.decrypt(b); err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	o := make(map[string]string)
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, err
+	}
+	// Reading to the end checks that an encrypted stream was not cut off.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// decrypt opens data encrypted with the key ring. Data written before
+// encryption was enabled is returned as is.
+func (f *FSM) decrypt(data []byte) ([]byte, error) {
+	if !kv.IsEncrypted(data) {
+		return data, nil
+	}
+
+	if f.KeyRing == nil {
+		return nil, fmt.Errorf("data is encrypted but no key ring is configured")
+	}
+
+	return f.KeyRing.Decrypt(data)
 }
 
 func (f *FSM) applySet(key, value string) interface{} {
-	f.KV.Set(key, value)
-	return nil
+	return f.Engine.Set(key, value)
 }
 
 func (f *FSM) applyDelete(key string) interface{} {
-	f.KV.Delete(key)
-	return nil
+	return f.Engine.Delete(key)
+}
+
+func (f *FSM) applyBatch(batch []command) interface{} {
+	ops := make([]kv.BatchOp, 0, len(batch))
+	for _, c := range batch {
+		switch c.Op {
+		case "set":
+			ops = append(ops, kv.BatchOp{Key: c.Key, Value: c.Value})
+		case "delete":
+			ops = append(ops, kv.BatchOp{Key: c.Key, Delete: true})
+		default:
+			panic(fmt.Sprintf("unrecognized command op in batch: %s", c.Op))
+		}
+	}
+
+	return f.Engine.Batch(ops)
 }
 
 type fsmSnapshot struct {
-	store map[string]string
+	store   map[string]string
+	keyRing *kv.KeyRing
 }
 
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
-	err := func() error {
-		// Encode data.
-		b, err := json.Marshal(f.store)
-		if err != nil {
+	err := writeSnapshot(sink, f.keyRing, func(fn func(key, value string) error) error {
+		for k, v := range f.store {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		// Close the sink.
+		err = sink.Close()
+	}
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+// writeSnapshot writes the entries iterate yields to w as a JSON object,
+// which readSnapshot reads, one at a time. With a key ring the object is
+// sealed with EncryptWriter.
+func writeSnapshot(w io.Writer, keyRing *kv.KeyRing, iterate func(fn func(key, value string) error) error) error {
+	var sealed io.WriteCloser
+	if keyRing != nil {
+		var err error
+		if sealed, err = keyRing.EncryptWriter(w); err != nil {
 			return err
 		}
+		w = sealed
+	}
 
-		// Write data to sink.
-		if _, err := sink.Write(b); err != nil {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+
+	first := true
+	err := iterate(func(key, value string) error {
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
 			return err
 		}
 
-		// Close the sink.
-		return sink.Close()
-	}()
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+
+		bw.Write(k)
+		bw.WriteByte(':')
+		_, err = bw.Write(v)
 
+		return err
+	})
 	if err != nil {
-		sink.Cancel()
+		return err
 	}
 
-	return err
+	bw.WriteByte('}')
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if sealed != nil {
+		return sealed.Close()
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written to it and drops them.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+
+	return len(p), nil
 }
 
 func (f *fsmSnapshot) Release() {
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "buffer" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func applyCommand(t *testing.T, f *FSM, c command) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	f.Apply(&raft.Log{Data: b})
+}
+
+func TestFSMMemoryEngine(t *testing.T) {
+	store := NewStoreWithEngine(kv.NewMemoryKV(), "", "")
+	fsm := (*FSM)(store)
+
+	applyCommand(t, fsm, command{Op: "set", Key: "a", Value: "1"})
+	applyCommand(t, fsm, command{Op: "batch", Batch: []command{
+		{Op: "set", Key: "b", Value: "2"},
+		{Op: "delete", Key: "a"},
+	}})
+
+	if _, err := store.Get("a"); err == nil {
+		t.Errorf("Expected `a` to be deleted\n")
+	}
+	if val, _ := store.Get("b"); val != "2" {
+		t.Errorf("Expected `2`. Got `%v`\n", val)
+	}
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	restored := NewStoreWithEngine(kv.NewMemoryKV(), "", "")
+	if err := (*FSM)(restored).Restore(ioutil.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if val, _ := restored.Get("b"); val != "2" {
+		t.Errorf("Expected `2`. Got `%v`\n", val)
+	}
+
+	if _, err := store.Backup(ioutil.Discard, nil); err == nil {
+		t.Errorf("Expected backups to be unsupported by the memory engine\n")
+	}
+}
+
+func TestStoreContext(t *testing.T) {
+	raftAddr := ":12002"
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(filepath.Join(tmpDir, "data.db"), filepath.Join(tmpDir, "indexes.idx"), 1000, 10000, filepath.Join(tmpDir, "raft"), raftAddr)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if err := store.Open(true, "node1"); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	if err := store.SetContext(context.Background(), "key", "value"); err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	}
+	// SetContext returns once the set is applied.
+	if val, _ := store.Get("key"); val != "value" {
+		t.Errorf("Expected `value`. Got `%v`\n", val)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.SetContext(ctx, "key", "other"); err != context.Canceled {
+		t.Errorf("Expected `%v`. Got `%v`\n", context.Canceled, err)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if err := store.DeleteContext(ctx, "key"); err != context.DeadlineExceeded {
+		t.Errorf("Expected `%v`. Got `%v`\n", context.DeadlineExceeded, err)
+	}
+
+	if val, _ := store.Get("key"); val != "value" {
+		t.Errorf("Expected `value`. Got `%v`\n", val)
+	}
+}
+
+func openTestStore(t *testing.T, dir, nodeID, raftAddr string, bootstrap bool) *Store {
+	dir = filepath.Join(dir, nodeID)
+	os.MkdirAll(dir, 0755)
+
+	store, err := NewStore(filepath.Join(dir, "data.db"), filepath.Join(dir, "indexes.idx"), 1000, 10000, filepath.Join(dir, "raft"), raftAddr)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if err := store.Open(bootstrap, nodeID); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	return store
+}
+
+func TestStoreIngest(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	leader := openTestStore(t, tmpDir, "node1", "127.0.0.1:12003", true)
+	time.Sleep(3 * time.Second)
+
+	follower := openTestStore(t, tmpDir, "node2", "127.0.0.1:12004", false)
+	if err := leader.Join("node2", "127.0.0.1:12004"); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	if err := leader.Set("kept", "value"); err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	d := kv.NewDumpWriter(buf, kv.JSONLines)
+	for i := 0; i < 100; i++ {
+		d.Write(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	d.Close()
+
+	n, err := leader.Ingest(buf, kv.JSONLines)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if n != 100 {
+		t.Errorf("Expected `100`. Got `%v`\n", n)
+	}
+
+	// The follower catches up by installing the snapshot.
+	for i := 0; i < 100; i++ {
+		if _, err := follower.Get("key_99"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, store := range []*Store{leader, follower} {
+		for i := 0; i < 100; i++ {
+			if val, _ := store.Get(fmt.Sprintf("key_%d", i)); val != fmt.Sprintf("value_%d", i) {
+				t.Errorf("Expected `value_%d`. Got `%v`\n", i, val)
+			}
+		}
+		if val, _ := store.Get("kept"); val != "value" {
+			t.Errorf("Expected `value`. Got `%v`\n", val)
+		}
+	}
+}
+
+func TestStoreIngestConcurrentWrites(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12009", true)
+	defer store.Close()
+	time.Sleep(3 * time.Second)
+
+	N := 20000
+	buf := bytes.NewBuffer([]byte{})
+	d := kv.NewDumpWriter(buf, kv.JSONLines)
+	for i := 0; i < N; i++ {
+		d.Write(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	d.Close()
+
+	stop := make(chan struct{})
+	written := make(chan int)
+	started := make(chan struct{})
+
+	go func() {
+		n := 0
+		defer func() { written <- n }()
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if err := store.Set(fmt.Sprintf("write_%d", n), fmt.Sprintf("value_%d", n)); err != nil {
+				t.Errorf("Expected `nil`. Got `%v`\n", err)
+				return
+			}
+			n++
+
+			if n == 10 {
+				close(started)
+			}
+		}
+	}()
+
+	<-started
+	n, err := store.Ingest(buf, kv.JSONLines)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if n != N {
+		t.Errorf("Expected `%d`. Got `%v`\n", N, n)
+	}
+
+	// Let a few writes go through after the ingest too.
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	writes := <-written
+
+	for i := 0; i < writes; i++ {
+		if val, _ := store.Get(fmt.Sprintf("write_%d", i)); val != fmt.Sprintf("value_%d", i) {
+			t.Errorf("Expected `value_%d`. Got `%v`\n", i, val)
+		}
+	}
+	for i := 0; i < N; i++ {
+		if val, _ := store.Get(fmt.Sprintf("key_%d", i)); val != fmt.Sprintf("value_%d", i) {
+			t.Errorf("Expected `value_%d`. Got `%v`\n", i, val)
+		}
+	}
+}
+
+func TestStoreIngestRestoreError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12012", true)
+	defer store.Close()
+	time.Sleep(3 * time.Second)
+
+	if err := store.Set("kept", "value"); err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	d := kv.NewDumpWriter(buf, kv.JSONLines)
+	for i := 0; i < 100; i++ {
+		d.Write(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	d.Close()
+	dump := buf.Bytes()
+
+	// Raft cannot create the snapshot with a file in place of its snapshot
+	// directory.
+	snapshots := filepath.Join(tmpDir, "node1", "raft", "snapshots")
+	os.RemoveAll(snapshots)
+	ioutil.WriteFile(snapshots, nil, 0644)
+
+	if _, err := store.Ingest(bytes.NewReader(dump), kv.JSONLines); err == nil {
+		t.Errorf("Expected the ingest to fail\n")
+	}
+	if _, err := store.Get("key_0"); err == nil {
+		t.Errorf("Expected `key_0` not to be ingested\n")
+	}
+	if val, _ := store.Get("kept"); val != "value" {
+		t.Errorf("Expected `value`. Got `%v`\n", val)
+	}
+
+	os.Remove(snapshots)
+	os.MkdirAll(snapshots, 0755)
+
+	n, err := store.Ingest(bytes.NewReader(dump), kv.JSONLines)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if n != 100 {
+		t.Errorf("Expected `100`. Got `%v`\n", n)
+	}
+	if val, _ := store.Get("key_0"); val != "value_0" {
+		t.Errorf("Expected `value_0`. Got `%v`\n", val)
+	}
+	if err := store.Set("after", "value"); err != nil {
+		t.Errorf("Expected `nil`. Got `%v`\n", err)
+	}
+}
+
+func TestSnapshotEncrypted(t *testing.T) {
+	keyRing, _ := kv.ParseKeyRing("1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+	store := NewStoreWithEngine(kv.NewMemoryKV(), "", "")
+	store.KeyRing = keyRing
+	fsm := (*FSM)(store)
+
+	applyCommand(t, fsm, command{Op: "set", Key: "a", Value: "1"})
+	applyCommand(t, fsm, command{Op: "set", Key: "b", Value: strings.Repeat("2", 200000)})
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if !kv.IsEncryptedStream(sink.Bytes()) {
+		t.Errorf("Expected the snapshot to be encrypted\n")
+	}
+
+	restored := NewStoreWithEngine(kv.NewMemoryKV(), "", "")
+	restored.KeyRing = keyRing
+	if err := (*FSM)(restored).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes()))); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if val, _ := restored.Get("b"); len(val) != 200000 {
+		t.Errorf("Expected `200000`. Got `%v`\n", len(val))
+	}
+
+	// A snapshot cut off is refused.
+	cut := NewStoreWithEngine(kv.NewMemoryKV(), "", "")
+	cut.KeyRing = keyRing
+	if err := (*FSM)(cut).Restore(ioutil.NopCloser(bytes.NewReader(sink.Bytes()[:sink.Len()/2]))); err == nil {
+		t.Errorf("Expected an error for a truncated snapshot\n")
+	}
+}
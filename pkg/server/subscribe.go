@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/raft"
+)
+
+// Subscribe calls fn with the changes made by the Raft log entries after
+// fromIndex, in the order of the log, and then with every change applied from
+// then on, until ctx is done or fn returns an error, which Subscribe returns.
+// A fromIndex of 0 replays the whole log.
+//
+// The changes are read from the log of the node, so a subscriber that is slow
+// or reconnects misses nothing as long as it resumes from the index of the
+// last event it handled. Entries that were already compacted into a snapshot
+// are replaced by an event of op "reset", after which the keys of the snapshot
+// are sent as sets, all with the index of the snapshot. Entries the FSM
+// failed to apply changed nothing and are skipped.
+func (s *Store) Subscribe(ctx context.Context, fromIndex uint64, fn func(*ChangeEvent) error) error {
+	if s.raft == nil {
+		return fmt.Errorf("store is not open")
+	}
+
+	next := fromIndex + 1
+	for {
+		// Taken before the log is read, so that no change applied meanwhile
+		// is waited for.
+		last, changed := s.watch.changed()
+		if applied := s.raft.AppliedIndex(); applied > last {
+			last = applied
+		}
+
+		for next <= last {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Entries the FSM failed to apply changed nothing. What the
+			// entries before the snapshot it restored changed is only
+			// known from a snapshot.
+			known, failed := s.watch.outcome(next)
+			if failed {
+				next++
+				continue
+			}
+
+			var l raft.Log
+			err := s.logs.GetLog(next, &l)
+			if err == raft.ErrLogNotFound || !known {
+				index, err := s.replaySnapshot(ctx, next, fn)
+				if err != nil {
+					return err
+				}
+
+				next = index + 1
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := s.replayLog(&l, fn); err != nil {
+				return err
+			}
+			next++
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// replayLog calls fn with the changes made by the log entry l.
+func (s *Store) replayLog(l *raft.Log, fn func(*ChangeEvent) error) error {
+	if l.Type != raft.LogCommand {
+		return nil
+	}
+
+	data, err := (*FSM)(s).decrypt(l.Data)
+	if err != nil {
+		return err
+	}
+
+	var c command
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+
+	for _, op := range c.ops() {
+		e := &ChangeEvent{Index: l.Index, Op: op.Op, Key: op.Key, Value: op.Value, Timestamp: c.Time}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaySnapshot calls fn with a reset event and the keys of the latest
+// snapshot, which must hold the changes of the log entry at index, and
+// returns the index of the snapshot.
+func (s *Store) replaySnapshot(ctx context.Context, index uint64, fn func(*ChangeEvent) error) (uint64, error) {
+	metas, err := s.snapshots.List()
+	if err != nil {
+		return 0, err
+	}
+	if len(metas) == 0 || metas[0].Index < index {
+		return 0, fmt.Errorf("log entry %d is neither in the log nor in a snapshot", index)
+	}
+
+	meta, rc, err := s.snapshots.Open(metas[0].ID)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	items, err := (*FSM)(s).readSnapshot(rc)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := fn(&ChangeEvent{Index: meta.Index, Op: "reset"}); err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if err := fn(&ChangeEvent{Index: meta.Index, Op: "set", Key: key, Value: items[key]}); err != nil {
+			return 0, err
+		}
+	}
+
+	return meta.Index, nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+)
+
+func receiveChange(t *testing.T, events <-chan *ChangeEvent) *ChangeEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an event. Got nothing\n")
+		return nil
+	}
+}
+
+func subscribe(ctx context.Context, store *Store, fromIndex uint64) <-chan *ChangeEvent {
+	events := make(chan *ChangeEvent, 100)
+	go store.Subscribe(ctx, fromIndex, func(e *ChangeEvent) error {
+		events <- e
+		return nil
+	})
+
+	return events
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12007", true)
+	defer store.Close()
+	time.Sleep(3 * time.Second)
+
+	store.Set("a", "1")
+	store.Delete("a")
+	store.Set("b", "2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := subscribe(ctx, store, 0)
+
+	var last uint64
+	for _, expected := range []ChangeEvent{{Op: "set", Key: "a", Value: "1"}, {Op: "delete", Key: "a"}, {Op: "set", Key: "b", Value: "2"}} {
+		e := receiveChange(t, events)
+		if e.Op != expected.Op || e.Key != expected.Key || e.Value != expected.Value {
+			t.Errorf("Expected `%v`. Got `%v`\n", &expected, e)
+		}
+		if e.Index <= last || e.Timestamp == 0 {
+			t.Errorf("Expected an index after %d and a timestamp. Got `%v`\n", last, e)
+		}
+		last = e.Index
+	}
+
+	store.Set("c", "3")
+	if e := receiveChange(t, events); e.Op != "set" || e.Key != "c" || e.Index <= last {
+		t.Errorf("Expected `set c 3` after index %d. Got `%v`\n", last, e)
+	}
+
+	resumed := subscribe(ctx, store, last)
+	if e := receiveChange(t, resumed); e.Op != "set" || e.Key != "c" {
+		t.Errorf("Expected `set c 3`. Got `%v`\n", e)
+	}
+
+	if err := store.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	var replayed []*ChangeEvent
+	index, err := store.replaySnapshot(ctx, last, func(e *ChangeEvent) error {
+		replayed = append(replayed, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	if index <= last {
+		t.Errorf("Expected a snapshot index after %d. Got `%d`\n", last, index)
+	}
+	if len(replayed) != 3 || replayed[0].Op != "reset" || replayed[1].Key != "b" || replayed[2].Key != "c" {
+		t.Errorf("Expected `reset`, `set b` and `set c`. Got `%v`\n", replayed)
+	}
+}
+
+// failingEngine fails to set the key bad.
+type failingEngine struct {
+	kv.Engine
+}
+
+func (e *failingEngine) Set(key, value string) error {
+	if key == "bad" {
+		return errors.New("disk is full")
+	}
+	return e.Engine.Set(key, value)
+}
+
+func TestStoreSubscribeApplyError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := NewStoreWithEngine(&failingEngine{kv.NewMemoryKV()}, filepath.Join(tmpDir, "raft"), "127.0.0.1:12010")
+	if err := store.Open(true, "node1"); err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	defer store.Close()
+	time.Sleep(3 * time.Second)
+
+	store.Set("a", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := subscribe(ctx, store, 0)
+	if e := receiveChange(t, events); e.Op != "set" || e.Key != "a" {
+		t.Errorf("Expected `set a 1`. Got `%v`\n", e)
+	}
+
+	if err := store.Set("bad", "2"); err == nil {
+		t.Errorf("Expected an error. Got `nil`\n")
+	}
+
+	// The subscriber is woken up by the failed entry, which changed nothing.
+	index := store.raft.AppliedIndex()
+	if last, changed := store.watch.changed(); last < index {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected to be woken up by entry %d\n", index)
+		}
+	}
+	if known, failed := store.watch.outcome(index); !known || !failed {
+		t.Errorf("Expected entry %d to be known to have failed. Got `%v` and `%v`\n", index, known, failed)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("Expected no event. Got `%v`\n", e)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	store.Set("c", "3")
+	if e := receiveChange(t, events); e.Op != "set" || e.Key != "c" {
+		t.Errorf("Expected `set c 3`. Got `%v`\n", e)
+	}
+
+	// Replaying the log skips the failed entry too.
+	replayed := subscribe(ctx, store, 0)
+	for _, key := range []string{"a", "c"} {
+		if e := receiveChange(t, replayed); e.Op != "set" || e.Key != key {
+			t.Errorf("Expected `set %s`. Got `%v`\n", key, e)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	kv "github.com/kgantsov/kvgo/pkg/kv"
+)
+
+const (
+	// watchHistorySize is the number of recent changes a Store keeps for
+	// watchers resuming from a Raft index.
+	watchHistorySize = 10000
+
+	// watchBufferSize is the number of events a watcher can fall behind by
+	// before it is dropped.
+	watchBufferSize = 1024
+)
+
+// ErrIndexTooOld is returned by Store.Watch for indexes older than the
+// changes the store still remembers.
+var ErrIndexTooOld = errors.New("index is older than the watch history")
+
+type storeWatcher struct {
+	prefix string
+	after  uint64
+	ch     chan *WatchEvent
+}
+
+// watchHub delivers the changes applied by the FSM to the watchers of a
+// Store and keeps the most recent ones for watchers that resume.
+type watchHub struct {
+	lock     sync.Mutex
+	watchers map[*storeWatcher]struct{}
+
+	// history holds the latest changes, which are all of the changes with
+	// an index above floor. floor is unknown until the first change after
+	// the store starts or restores a snapshot.
+	history    []*WatchEvent
+	floor      uint64
+	floorKnown bool
+
+	// last is the index of the latest log entry applied, and applied is
+	// closed once another one is; see changed.
+	last    uint64
+	applied chan struct{}
+
+	// failed holds the indexes of the log entries after start that the FSM
+	// failed to apply. The entries up to start were applied before the
+	// snapshot the FSM restored last was taken, so only the snapshot tells
+	// what they changed. restored is set from when a snapshot is restored
+	// until the next entry is applied, which sets start.
+	failed   map[uint64]bool
+	start    uint64
+	restored bool
+}
+
+// Watch returns a channel that receives every change applied to a key
+// starting with prefix, in the order of the Raft log, and a function that
+// stops the watch and closes the channel. If fromIndex is not 0 the changes
+// of the log entries after it are sent first, or ErrIndexTooOld is returned
+// if they are not all remembered any more.
+//
+// A watcher that falls too far behind receives an event of type "overflow"
+// and its channel is closed; it can start over from the index of the last
+// event it received. So does a watcher when the store restores a snapshot,
+// since changes may then have been missed.
+func (s *Store) Watch(prefix string, fromIndex uint64) (<-chan *WatchEvent, func(), error) {
+	h := &s.watch
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	w := &storeWatcher{prefix: prefix, after: fromIndex, ch: make(chan *WatchEvent, watchBufferSize+1)}
+
+	if fromIndex > 0 {
+		// Without a floor no change was applied since the store started or
+		// restored a snapshot, so the state covers everything applied.
+		if h.floorKnown && fromIndex < h.floor {
+			return nil, nil, ErrIndexTooOld
+		}
+		if !h.floorKnown && (s.raft == nil || fromIndex < s.raft.AppliedIndex()) {
+			return nil, nil, ErrIndexTooOld
+		}
+
+		for _, e := range h.history {
+			if !w.send(e) {
+				close(w.ch)
+				return w.ch, func() {}, nil
+			}
+		}
+	}
+
+	if h.watchers == nil {
+		h.watchers = make(map[*storeWatcher]struct{})
+	}
+	h.watchers[w] = struct{}{}
+
+	return w.ch, func() {
+		h.lock.Lock()
+		h.remove(w)
+		h.lock.Unlock()
+	}, nil
+}
+
+// publish sends the changes made by the command c at index to the watchers
+// and adds them to the history.
+func (h *watchHub) publish(index uint64, c *command) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.begin(index)
+	if !h.floorKnown {
+		h.floor = index - 1
+		h.floorKnown = true
+	}
+
+	for _, op := range c.ops() {
+		e := &WatchEvent{Type: kv.EventSet.String(), Key: op.Key, Value: op.Value, Index: index}
+		if op.Op == "delete" {
+			e = &WatchEvent{Type: kv.EventDelete.String(), Key: op.Key, Index: index}
+		}
+
+		h.history = append(h.history, e)
+
+		for w := range h.watchers {
+			if !w.send(e) {
+				h.remove(w)
+			}
+		}
+	}
+
+	// The history is trimmed in steps so that it is not copied on every
+	// change.
+	if len(h.history) >= 2*watchHistorySize {
+		dropped := len(h.history) - watchHistorySize
+		h.floor = h.history[dropped-1].Index
+		h.history = append([]*WatchEvent(nil), h.history[dropped:]...)
+	}
+
+	h.last = index
+	h.notify()
+}
+
+// fail records that the FSM failed to apply the log entry at index, which
+// changed nothing. Failures of entries before first, the first index still
+// in the log, are forgotten.
+func (h *watchHub) fail(index, first uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.begin(index)
+
+	if h.failed == nil {
+		h.failed = make(map[uint64]bool)
+	}
+	for i := range h.failed {
+		if i < first {
+			delete(h.failed, i)
+		}
+	}
+	h.failed[index] = true
+
+	h.last = index
+	h.notify()
+}
+
+// begin sets start if index is the first entry applied since a snapshot was
+// restored. The caller must hold lock.
+func (h *watchHub) begin(index uint64) {
+	if h.restored {
+		h.start = index - 1
+		h.restored = false
+	}
+}
+
+// outcome reports whether it is known if the log entry at index was applied,
+// and if so whether the FSM failed to apply it.
+func (h *watchHub) outcome(index uint64) (known, failed bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.restored || index <= h.start {
+		return false, false
+	}
+
+	return true, h.failed[index]
+}
+
+// changed returns the index of the latest log entry applied and a channel
+// closed once another one is applied or a snapshot restored.
+func (h *watchHub) changed() (uint64, <-chan struct{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.applied == nil {
+		h.applied = make(chan struct{})
+	}
+
+	return h.last, h.applied
+}
+
+// notify wakes up those waiting on changed. The caller must hold lock.
+func (h *watchHub) notify() {
+	if h.applied != nil {
+		close(h.applied)
+		h.applied = nil
+	}
+}
+
+// reset drops every watcher and forgets the history, for when the store
+// restores a snapshot.
+func (h *watchHub) reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for w := range h.watchers {
+		w.ch <- &WatchEvent{Type: kv.EventOverflow.String()}
+		h.remove(w)
+	}
+
+	h.history = nil
+	h.floorKnown = false
+	h.failed = nil
+	h.restored = true
+	h.notify()
+}
+
+// closeAll stops every watcher.
+func (h *watchHub) closeAll() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for w := range h.watchers {
+		h.remove(w)
+	}
+}
+
+// remove stops w. The caller must hold lock.
+func (h *watchHub) remove(w *storeWatcher) {
+	if _, ok := h.watchers[w]; !ok {
+		return
+	}
+
+	delete(h.watchers, w)
+	close(w.ch)
+}
+
+// send sends e to w if it is a change to a key with the prefix of w after the
+// index w started from. If w is too far behind it is sent an overflow event
+// instead and false is returned; w must then be stopped.
+func (w *storeWatcher) send(e *WatchEvent) bool {
+	if e.Index <= w.after || !strings.HasPrefix(e.Key, w.prefix) {
+		return true
+	}
+
+	if len(w.ch) == cap(w.ch)-1 {
+		w.ch <- &WatchEvent{Type: kv.EventOverflow.String()}
+		return false
+	}
+
+	w.ch <- e
+	return true
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan *WatchEvent) *WatchEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an event. Got nothing\n")
+		return nil
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "kvgo_tests")
+	defer os.RemoveAll(tmpDir)
+
+	store := openTestStore(t, tmpDir, "node1", "127.0.0.1:12005", true)
+	defer store.Close()
+	time.Sleep(3 * time.Second)
+
+	events, cancel, err := store.Watch("config/", 0)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+
+	store.Set("config/a", "1")
+	store.Set("other", "1")
+	store.Delete("config/a")
+
+	set := receiveEvent(t, events)
+	if set.Type != "set" || set.Key != "config/a" || set.Value != "1" {
+		t.Errorf("Expected `set config/a 1`. Got `%v`\n", set)
+	}
+
+	del := receiveEvent(t, events)
+	if del.Type != "delete" || del.Key != "config/a" || del.Index <= set.Index {
+		t.Errorf("Expected `delete config/a` after index %d. Got `%v`\n", set.Index, del)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected channel to be closed\n")
+	}
+
+	resumed, cancel, err := store.Watch("config/", set.Index)
+	if err != nil {
+		t.Fatalf("Expected `nil`. Got `%v`\n", err)
+	}
+	defer cancel()
+
+	if e := receiveEvent(t, resumed); e.Type != "delete" || e.Index != del.Index {
+		t.Errorf("Expected `delete config/a` at index %d. Got `%v`\n", del.Index, e)
+	}
+
+	store.Set("config/b", "2")
+	if e := receiveEvent(t, resumed); e.Type != "set" || e.Key != "config/b" {
+		t.Errorf("Expected `set config/b`. Got `%v`\n", e)
+	}
+
+	if _, _, err := store.Watch("config/", 1); err != ErrIndexTooOld {
+		t.Errorf("Expected `%v`. Got `%v`\n", ErrIndexTooOld, err)
+	}
+}